@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/go-routeros/routeros"
+)
+
+// apiClient is the binary API of RouterOS (api, api-ssl). The commands are run one at a time, as the replies
+// are read in the order of the commands, each with the deadline, so that a dead router does not hang the callers.
+type apiClient struct {
+	conn    net.Conn
+	client  *routeros.Client
+	timeout time.Duration
+	// The lock of the connection, a channel so that the waiting for it can be cancelled
+	lock chan struct{}
+}
+
+func dialAPI(address, user, password string, useTLS bool, tlsConfig *tls.Config, timeout time.Duration) (*apiClient, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	client, err := routeros.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := &apiClient{conn: conn, client: client, timeout: timeout, lock: make(chan struct{}, 1)}
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	if err := client.Login(user, password); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *apiClient) Run(sentence ...string) (*routeros.Reply, error) {
+	return c.RunContext(context.Background(), sentence...)
+}
+
+// RunContext gives up only while waiting for the previous commands: a command sent to the router is read to the end,
+// the reply of a dropped command would be read by the next one.
+func (c *apiClient) RunContext(ctx context.Context, sentence ...string) (*routeros.Reply, error) {
+	select {
+	case c.lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-c.lock }()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.client.Run(sentence...)
+}
+
+func (c *apiClient) Close() {
+	c.client.Close()
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type asInfo struct {
+	ASN   uint32
+	ASOrg string
+}
+
+type asnRange struct {
+	start, end net.IP
+	asInfo
+}
+
+// ASNDB is the autonomous systems of the remote addresses from a MaxMind DB file (GeoLite2-ASN)
+// or from the ip2asn TSV (https://iptoasn.com/, the .gz files are read as is).
+type ASNDB struct {
+	path    string
+	mmdb    *mmdbFile
+	modTime time.Time
+	ranges  []asnRange
+	orgs    map[uint32]string
+	cache   map[string]asInfo
+	sync.RWMutex
+}
+
+func newASNDB(path string) *ASNDB {
+	db := &ASNDB{path: path, orgs: map[uint32]string{}, cache: map[string]asInfo{}}
+	if strings.HasSuffix(path, ".mmdb") {
+		db.mmdb = newMMDBFile(path)
+	} else if path != "" {
+		db.reload()
+	}
+	return db
+}
+
+func (db *ASNDB) loopReload() {
+	for {
+		time.Sleep(time.Minute)
+		if db.reload() {
+			db.Lock()
+			db.cache = map[string]asInfo{}
+			db.Unlock()
+		}
+	}
+}
+
+func (db *ASNDB) reload() bool {
+	if db.mmdb != nil {
+		return db.mmdb.reload()
+	}
+	info, err := os.Stat(db.path)
+	if err != nil {
+		log.Errorf("Error reading %v:%v", db.path, err)
+		return false
+	}
+	db.RLock()
+	same := info.ModTime().Equal(db.modTime)
+	db.RUnlock()
+	if same {
+		return false
+	}
+	ranges, err := readIP2ASN(db.path)
+	if err != nil {
+		log.Errorf("Error reading %v:%v", db.path, err)
+		return false
+	}
+	orgs := map[uint32]string{}
+	for _, r := range ranges {
+		orgs[r.ASN] = r.ASOrg
+	}
+	db.Lock()
+	db.ranges, db.orgs, db.modTime = ranges, orgs, info.ModTime()
+	db.Unlock()
+	log.Infof("Loaded %v ranges of autonomous systems from %v", len(ranges), db.path)
+	return true
+}
+
+// readIP2ASN reads the lines "range_start<TAB>range_end<TAB>AS_number<TAB>country_code<TAB>AS_description".
+func readIP2ASN(path string) ([]asnRange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	ranges := []asnRange{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 5 {
+			continue
+		}
+		start, end := net.ParseIP(fields[0]).To16(), net.ParseIP(fields[1]).To16()
+		asn, err := strconv.ParseUint(fields[2], 10, 32)
+		// AS 0 is the address space not routed
+		if start == nil || end == nil || err != nil || asn == 0 {
+			continue
+		}
+		ranges = append(ranges, asnRange{start: start, end: end, asInfo: asInfo{ASN: uint32(asn), ASOrg: fields[4]}})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(ranges, func(i, j int) bool { return bytes.Compare(ranges[i].start, ranges[j].start) < 0 })
+	return ranges, nil
+}
+
+func (db *ASNDB) lookup(ip string) asInfo {
+	if db.path == "" {
+		return asInfo{}
+	}
+	db.RLock()
+	info, ok := db.cache[ip]
+	db.RUnlock()
+	if ok {
+		return info
+	}
+	if db.mmdb != nil {
+		record := db.mmdb.lookup(ip)
+		asn, _ := mmdbPath(record, "autonomous_system_number").(uint64)
+		info = asInfo{ASN: uint32(asn), ASOrg: mmdbPathString(record, "autonomous_system_organization")}
+	} else {
+		info = db.find(net.ParseIP(ip).To16())
+	}
+	db.Lock()
+	if len(db.cache) > 100000 {
+		db.cache = map[string]asInfo{}
+	}
+	db.cache[ip] = info
+	if info.ASN != 0 {
+		db.orgs[info.ASN] = info.ASOrg
+	}
+	db.Unlock()
+	return info
+}
+
+func (db *ASNDB) find(ip net.IP) asInfo {
+	if ip == nil {
+		return asInfo{}
+	}
+	db.RLock()
+	defer db.RUnlock()
+	i := sort.Search(len(db.ranges), func(i int) bool { return bytes.Compare(db.ranges[i].start, ip) > 0 }) - 1
+	if i < 0 || bytes.Compare(ip, db.ranges[i].end) > 0 {
+		return asInfo{}
+	}
+	return db.ranges[i].asInfo
+}
+
+// remoteAS returns the autonomous system of the remote address, the AS number from the exporter takes precedence.
+func (db *ASNDB) remoteAS(ip string, exporterAS uint16) asInfo {
+	info := db.lookup(ip)
+	if exporterAS == 0 || uint32(exporterAS) == info.ASN {
+		return info
+	}
+	db.RLock()
+	defer db.RUnlock()
+	return asInfo{ASN: uint32(exporterAS), ASOrg: db.orgs[uint32(exporterAS)]}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testIP2ASN = "1.0.0.0\t1.0.0.255\t13335\tUS\tCLOUDFLARENET\n" +
+	"1.0.4.0\t1.0.7.255\t38803\tAU\tWPL-AS-AP Wirefreebroadband Pty Ltd\n" +
+	"1.0.8.0\t1.0.15.255\t0\tNone\tNot routed\n" +
+	"broken line\n" +
+	"1.0.16.0\tnot-an-ip\t2519\tJP\tVECTANT\n" +
+	"2001:200::\t2001:200:ffff:ffff:ffff:ffff:ffff:ffff\t2500\tJP\tWIDE-BB WIDE Project\n"
+
+func TestIP2ASN(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "ip2asn-combined.tsv")
+	if err := ioutil.WriteFile(plain, []byte(testIP2ASN), 0644); err != nil {
+		t.Fatal(err)
+	}
+	compressed := filepath.Join(dir, "ip2asn-combined.tsv.gz")
+	f, err := os.Create(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte(testIP2ASN))
+	gz.Close()
+	f.Close()
+
+	for _, path := range []string{plain, compressed} {
+		db := newASNDB(path)
+		if len(db.ranges) != 3 {
+			t.Fatalf("%v: ranges %+v", path, db.ranges)
+		}
+		for ip, want := range map[string]asInfo{
+			"1.0.0.0":         {13335, "CLOUDFLARENET"},
+			"1.0.0.255":       {13335, "CLOUDFLARENET"},
+			"1.0.5.1":         {38803, "WPL-AS-AP Wirefreebroadband Pty Ltd"},
+			"1.0.2.1":         {},
+			"1.0.9.1":         {},
+			"9.9.9.9":         {},
+			"0.0.0.1":         {},
+			"2001:200:1::1":   {2500, "WIDE-BB WIDE Project"},
+			"2001:201::1":     {},
+			"not an address":  {},
+			"::ffff:1.0.4.10": {38803, "WPL-AS-AP Wirefreebroadband Pty Ltd"},
+		} {
+			if got := db.lookup(ip); got != want {
+				t.Errorf("%v %v: %+v instead of %+v", path, ip, got, want)
+			}
+		}
+	}
+
+	if _, err := readIP2ASN(plain + ".missing"); err == nil {
+		t.Error("no error for a missing file")
+	}
+	if err := ioutil.WriteFile(plain+".gz", []byte(testIP2ASN), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readIP2ASN(plain + ".gz"); err == nil {
+		t.Error("no error for a .gz file which is not compressed")
+	}
+}
+
+func TestRemoteAS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip2asn.tsv")
+	if err := ioutil.WriteFile(path, []byte(testIP2ASN), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db := newASNDB(path)
+	cases := []struct {
+		ip         string
+		exporterAS uint16
+		want       asInfo
+	}{
+		{"1.0.0.1", 0, asInfo{13335, "CLOUDFLARENET"}},
+		{"1.0.0.1", 13335, asInfo{13335, "CLOUDFLARENET"}},
+		// The AS from the exporter wins, the name is taken from the database
+		{"1.0.0.1", 2500, asInfo{2500, "WIDE-BB WIDE Project"}},
+		{"9.9.9.9", 64512, asInfo{64512, ""}},
+	}
+	for _, c := range cases {
+		if got := db.remoteAS(c.ip, c.exporterAS); got != c.want {
+			t.Errorf("%v/%v: %+v instead of %+v", c.ip, c.exporterAS, got, c.want)
+		}
+	}
+
+	if got := newASNDB("").remoteAS("1.0.0.1", 13335); got != (asInfo{ASN: 13335}) {
+		t.Errorf("without the database %+v", got)
+	}
+}
+
+func TestASNMMDB(t *testing.T) {
+	data := mmdbTestMap(
+		mmdbTestString("autonomous_system_number"), mmdbTestUint(mmdbUint32, 13335),
+		mmdbTestString("autonomous_system_organization"), mmdbTestString("CLOUDFLARENET"),
+	)
+	path := mmdbTestFile(t, 24, 6, []mmdbTestNetwork{{"1.0.0.0/24", 0}}, data)
+	mmdb := filepath.Join(filepath.Dir(path), "GeoLite2-ASN.mmdb")
+	if err := os.Rename(path, mmdb); err != nil {
+		t.Fatal(err)
+	}
+	db := newASNDB(mmdb)
+	if got := db.lookup("1.0.0.7"); got != (asInfo{13335, "CLOUDFLARENET"}) {
+		t.Errorf("lookup %+v", got)
+	}
+	if got := db.lookup("1.0.1.7"); got != (asInfo{}) {
+		t.Errorf("lookup outside of the networks %+v", got)
+	}
+	if got := db.remoteAS("9.9.9.9", 13335); got != (asInfo{13335, "CLOUDFLARENET"}) {
+		t.Errorf("the name of the exporter AS is not remembered: %+v", got)
+	}
+}
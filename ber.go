@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Minimal BER encoding (X.690) used by SNMP and LDAP. Only single-octet tags are supported.
+
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berNull        = 0x05
+	berOID         = 0x06
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+	berSet         = 0x31
+)
+
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func berEncode(tag byte, content []byte) []byte {
+	b := append([]byte{tag}, berLength(len(content))...)
+	return append(b, content...)
+}
+
+func berConstructed(tag byte, parts ...[]byte) []byte {
+	var content []byte
+	for _, part := range parts {
+		content = append(content, part...)
+	}
+	return berEncode(tag, content)
+}
+
+func berInt(tag byte, v int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		if (v >= -0x80 && v < 0x80) || len(b) == 8 {
+			break
+		}
+		v >>= 8
+	}
+	return berEncode(tag, b)
+}
+
+func berString(tag byte, s string) []byte {
+	return berEncode(tag, []byte(s))
+}
+
+func berBool(tag byte, v bool) []byte {
+	if v {
+		return berEncode(tag, []byte{0xff})
+	}
+	return berEncode(tag, []byte{0})
+}
+
+func berEncodeOID(oid []int) []byte {
+	if len(oid) < 2 {
+		return berEncode(berOID, nil)
+	}
+	b := []byte{byte(oid[0]*40 + oid[1])}
+	for _, n := range oid[2:] {
+		part := []byte{byte(n & 0x7f)}
+		for n >>= 7; n > 0; n >>= 7 {
+			part = append([]byte{byte(n&0x7f) | 0x80}, part...)
+		}
+		b = append(b, part...)
+	}
+	return berEncode(berOID, b)
+}
+
+// berRead reads one element and returns its tag, content and the rest of the buffer.
+func berRead(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("ber: short buffer")
+	}
+	tag = b[0]
+	length := int(b[1])
+	offset := 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(b) < 2+n {
+			return 0, nil, nil, errors.New("ber: wrong length")
+		}
+		length = 0
+		for _, c := range b[2 : 2+n] {
+			length = length<<8 | int(c)
+		}
+		offset += n
+	}
+	if length < 0 || len(b) < offset+length {
+		return 0, nil, nil, fmt.Errorf("ber: element of %v bytes does not fit into %v bytes", length, len(b)-offset)
+	}
+	return tag, b[offset : offset+length], b[offset+length:], nil
+}
+
+// berElements splits the content of a constructed element into the elements.
+func berElements(b []byte) ([]berElement, error) {
+	elements := []berElement{}
+	for len(b) > 0 {
+		tag, content, rest, err := berRead(b)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, berElement{tag, content})
+		b = rest
+	}
+	return elements, nil
+}
+
+type berElement struct {
+	tag     byte
+	content []byte
+}
+
+func berParseInt(b []byte) int64 {
+	var v int64
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		v = -1
+	}
+	for _, c := range b {
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+func berParseUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func berParseOID(b []byte) []int {
+	if len(b) == 0 {
+		return nil
+	}
+	oid := []int{int(b[0]) / 40, int(b[0]) % 40}
+	n := 0
+	for _, c := range b[1:] {
+		n = n<<7 | int(c&0x7f)
+		if c&0x80 == 0 {
+			oid = append(oid, n)
+			n = 0
+		}
+	}
+	return oid
+}
+
+func parseOID(s string) []int {
+	oid := []int{}
+	for _, part := range strings.Split(strings.Trim(s, "."), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil
+		}
+		oid = append(oid, n)
+	}
+	return oid
+}
+
+func oidHasPrefix(oid, prefix []int) bool {
+	if len(oid) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if oid[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// oidCompare orders the OIDs lexicographically, as the agents walk them.
+func oidCompare(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+func oidString(oid []int) string {
+	parts := make([]string, len(oid))
+	for i, n := range oid {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
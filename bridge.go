@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type bridgeHost struct {
+	Mac,
+	Port,
+	Bridge,
+	VLAN,
+	Router string
+}
+
+type arpSeen struct {
+	Mac      string
+	lastSeen time.Time
+}
+
+// Bridge correlates the bridge host table with the ARP entries seen earlier.
+// A device with a static address may have no fresh ARP entry, but while its MAC
+// is in the bridge host table it is still connected to the same port.
+type Bridge struct {
+	hosts    map[string]bridgeHost
+	knownArp map[string]arpSeen
+	sync.RWMutex
+}
+
+func (data *Transport) getBridgeHostsFromMT() {
+	hosts := map[string]bridgeHost{}
+	failed := data.pollRouters("bridge hosts", func(r *router) error {
+		reply, err := r.client.Run("/interface/bridge/host/print")
+		if err != nil {
+			return err
+		}
+		for _, re := range reply.Re {
+			if re.Map["local"] == "true" {
+				continue
+			}
+			host := newBridgeHost(r, re.Map)
+			if _, ok := hosts[host.Mac]; !ok {
+				hosts[host.Mac] = host
+			}
+		}
+		return nil
+	})
+	data.bridge.Lock()
+	for mac, host := range data.bridge.hosts {
+		if _, ok := hosts[mac]; !ok && failed[host.Router] {
+			hosts[mac] = host
+		}
+	}
+	data.bridge.hosts = hosts
+	data.bridge.Unlock()
+	log.Tracef("Get %v bridge hosts from mikrotik", len(hosts))
+}
+
+func newBridgeHost(r *router, m map[string]string) bridgeHost {
+	return bridgeHost{
+		Mac:    strings.ToUpper(m["mac-address"]),
+		Port:   m["on-interface"],
+		Bridge: m["bridge"],
+		VLAN:   m["vid"],
+		Router: r.name,
+	}
+}
+
+// remember keeps the IP to MAC pairs from ARP and DHCP for the fallback.
+func (b *Bridge) remember(ipToMac map[string]LineOfData, now time.Time, retention time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+	if b.knownArp == nil {
+		b.knownArp = map[string]arpSeen{}
+	}
+	for ip, line := range ipToMac {
+		if line.Mac == "" || line.Mac == ip || line.Service != "" {
+			continue
+		}
+		b.knownArp[ip] = arpSeen{Mac: strings.ToUpper(line.Mac), lastSeen: now}
+	}
+	for ip, seen := range b.knownArp {
+		if now.Sub(seen.lastSeen) > retention {
+			delete(b.knownArp, ip)
+		}
+	}
+}
+
+func (b *Bridge) apply(device *DeviceType) bool {
+	b.RLock()
+	defer b.RUnlock()
+	host, ok := b.hosts[strings.ToUpper(device.Mac)]
+	if !ok {
+		return false
+	}
+	device.BridgePort = host.Port
+	device.VLAN = host.VLAN
+	if device.Router == "" {
+		device.Router = host.Router
+	}
+	return true
+}
+
+// mergeInto puts the bridge port and VLAN into the lines and adds the devices
+// which are still in the bridge host table, but have lost the ARP entry.
+func (b *Bridge) mergeInto(ipToMac map[string]LineOfData, quota QuotaType) {
+	for ip, line := range ipToMac {
+		if b.apply(&line.DeviceType) {
+			ipToMac[ip] = line
+		}
+	}
+
+	b.RLock()
+	known := map[string]string{}
+	for ip, seen := range b.knownArp {
+		if _, ok := ipToMac[ip]; !ok {
+			known[ip] = seen.Mac
+		}
+	}
+	b.RUnlock()
+
+	for ip, mac := range known {
+		line := LineOfData{}
+		line.IP = ip
+		line.Mac = mac
+		if !b.apply(&line.DeviceType) {
+			continue
+		}
+		line.setDefaultQuotas(quota)
+		line.timeout = time.Now()
+		ipToMac[ip] = line
+	}
+}
+
+// resolveFromBridge is the fallback before using the IP address as the MAC address.
+func (data *Transport) resolveFromBridge(ctx context.Context, device *DeviceType) bool {
+	data.bridge.RLock()
+	seen, ok := data.bridge.knownArp[device.IP]
+	data.bridge.RUnlock()
+	if !ok {
+		return false
+	}
+	for _, r := range data.routersFor(device.IP) {
+		reply, err := r.client.RunContext(ctx, "/interface/bridge/host/print", "?mac-address="+seen.Mac)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			if err != errRouterDown {
+				log.Errorf("Error getting the bridge host of %v from %v:%v", seen.Mac, r.name, err)
+			}
+			continue
+		}
+		for _, re := range reply.Re {
+			host := newBridgeHost(r, re.Map)
+			device.Mac = seen.Mac
+			device.BridgePort = host.Port
+			device.VLAN = host.VLAN
+			device.Router = r.name
+			log.Tracef("IP:%v resolved from bridge host table of %v to MAC:%v on port:%v", device.IP, r.name, device.Mac, device.BridgePort)
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"os"
@@ -9,7 +10,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/go-routeros/routeros"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -20,10 +20,52 @@ type request struct {
 }
 
 type ResponseType struct {
-	IP       string `JSON:"IP"`
-	Mac      string `JSON:"Mac"`
-	HostName string `JSON:"Hostname"`
-	Comments string `JSON:"Comment"`
+	IP         string `JSON:"IP"`
+	Mac        string `JSON:"Mac"`
+	DeviceID   string `JSON:"DeviceID"`
+	Source     string `JSON:"Source"`
+	HostName   string `JSON:"Hostname"`
+	Comments   string `JSON:"Comment"`
+	Login      string `JSON:"Login"`
+	SSID       string `JSON:"SSID"`
+	AP         string `JSON:"AP"`
+	Signal     string `JSON:"Signal"`
+	Roams      int    `JSON:"Roams"`
+	BridgePort string `JSON:"BridgePort"`
+	VLAN       string `JSON:"VLAN"`
+	Interface  string `JSON:"Interface"`
+	Vendor     string `JSON:"Vendor"`
+	Router     string `JSON:"Router"`
+}
+
+func (response *ResponseType) fromLine(line LineOfData) {
+	response.Mac = line.Mac
+	response.DeviceID = line.DeviceID
+	response.Source = line.Source
+	response.Router = line.Router
+	response.IP = line.IP
+	response.HostName = line.HostName
+	response.Comments = line.Comment
+	response.Login = line.Login
+	response.SSID = line.SSID
+	response.AP = line.AP
+	response.Signal = line.Signal
+	response.Roams = line.Roams
+	response.BridgePort = line.BridgePort
+	response.VLAN = line.VLAN
+	response.Interface = line.Interface
+	response.Vendor = line.Vendor
+}
+
+// userName returns the value for the username field of the squid log.
+func (response ResponseType) userName() string {
+	if response.Login != "" {
+		return response.Login
+	}
+	if response.DeviceID != "" {
+		return response.DeviceID
+	}
+	return response.Mac
 }
 
 type Transport struct {
@@ -32,19 +74,41 @@ type Transport struct {
 	fileDestination     *os.File
 	csvFiletDestination *os.File
 	conn                *net.UDPConn
-	clientROS           *routeros.Client
+	routers             []*router
+	routerConnected     chan struct{}
 	renewOneMac         chan string
 	exitChan            chan os.Signal
+	hotSpot             HotSpot
+	ppp                 PPP
+	wireGuard           WireGuard
+	wireless            Wireless
+	bridge              Bridge
+	radius              Radius
+	leaseFiles          *LeaseFiles
+	localNeighbors      Neighbors
+	snmp                *SNMPSource
+	static              *StaticDevices
+	directory           *Directory
+	oui                 *OUI
+	geoIP               *GeoIP
+	asnDB               *ASNDB
+	interfaces          Interfaces
+	nat                 NAT
+	identities          *Identities
+	resolvers           resolverChain
+	dnsCache            DNSCache
+	dnsTap              DNSTap
 	QuotaType
 	sync.RWMutex
 }
 
 func NewTransport(cfg *Config) *Transport {
 
-	c, err := dial(cfg.MTAddr, cfg.MTUser, cfg.MTPass, cfg.UseTLS)
-	if err != nil {
-		log.Errorf("Error connect to %v:%v", cfg.MTAddr, err)
-		c = tryingToReconnectToMokrotik(cfg.MTAddr, cfg.MTUser, cfg.MTPass, cfg.UseTLS, cfg.NumOfTryingConnectToMT)
+	var err error
+	routerConnected := make(chan struct{}, 1)
+	routers := newRouters(cfg, routerConnected)
+	if len(routers) == 0 {
+		log.Info("The address of the Mikrotik router is not specified, only the other sources of the devices are used")
 	}
 	// defer c.Close()
 
@@ -67,146 +131,97 @@ func NewTransport(cfg *Config) *Transport {
 		Location = time.UTC
 	}
 
-	return &Transport{
+	data := &Transport{
 		ipToMac:             make(map[string]LineOfData),
 		renewOneMac:         make(chan string, 100),
 		Location:            Location,
 		exitChan:            getExitSignalsChannel(),
-		clientROS:           c,
+		routers:             routers,
+		routerConnected:     routerConnected,
+		leaseFiles:          newLeaseFiles(cfg.LeaseFiles),
+		snmp:                newSNMPSource(cfg.SNMPExporters, cfg.SNMPTimeout),
+		static:              newStaticDevices(cfg.StaticDevices, cfg.StaticDevicesPriority),
+		directory:           newDirectory(cfg.LDAPLookup, cfg.LDAPAttributes),
+		oui:                 newOUI(cfg.OUIFile),
+		geoIP:               newGeoIP(cfg.GeoIPFile, cfg.GeoIPCountries),
+		asnDB:               newASNDB(cfg.ASNFile),
+		identities:          newIdentities(cfg.IdentityFile),
 		fileDestination:     fileDestination,
 		csvFiletDestination: csvFiletDestination,
 	}
+	data.resolvers = data.newResolverChain(cfg.Resolvers, cfg.ResolverTimeout)
+	return data
 }
 
-func dial(MTAddr, MTUser, MTPass string, UseTLS bool) (*routeros.Client, error) {
-	if UseTLS {
-		return routeros.DialTLS(MTAddr, MTUser, MTPass, nil)
-	}
-	return routeros.Dial(MTAddr, MTUser, MTPass)
+// connectedToMT reports whether the Mikrotik routers are used as the source of the devices.
+func (data *Transport) connectedToMT() bool {
+	return len(data.routers) > 0
 }
 
-func tryingToReconnectToMokrotik(MTAddr, MTUser, MTPass string, UseTLS bool, NumOfTryingConnectToMT int) *routeros.Client {
-	c, err := dial(MTAddr, MTUser, MTPass, UseTLS)
-	if err != nil {
-		if NumOfTryingConnectToMT == 0 {
-			log.Fatalf("Error connect to %v:%v", MTAddr, err)
-		} else if NumOfTryingConnectToMT < 0 {
-			NumOfTryingConnectToMT = -1
+// dial connects to the router over the binary API (api, api-ssl) or the REST API of RouterOS v7.
+func dial(r *router) (routerClient, error) {
+	timeout := parseDurationOr(cfg.MTTimeout, 30*time.Second)
+	// The clients are returned only without errors, so that a nil client is not a non-nil interface
+	if r.backend == "rest" {
+		c, err := dialREST(r.address, r.user, r.password, r.useTLS, cfg.MTInsecureTLS, timeout)
+		if err != nil {
+			return nil, err
 		}
-		log.Errorf("Error connect to %v:%v", MTAddr, err)
-		time.Sleep(15 * time.Second)
-		NumOfTryingConnectToMT--
-		c = tryingToReconnectToMokrotik(MTAddr, MTUser, MTPass, UseTLS, NumOfTryingConnectToMT)
+		return c, nil
+	}
+	c, err := dialAPI(r.address, r.user, r.password, r.useTLS, &tls.Config{InsecureSkipVerify: cfg.MTInsecureTLS}, timeout)
+	if err != nil {
+		return nil, err
 	}
-	return c
+	return c, nil
 }
 
 func (data *Transport) GetInfo(request *request) ResponseType {
 	var response ResponseType
 
-	// timeInt, err := strconv.ParseInt(request.Time, 10, 64)
-	// if err != nil {
-	// 	log.Errorf("Error parsing timeStamp(%v) from request:%v", timeInt, err)
-	// 	//With an incorrect time, removes 30 seconds from the current time to be able to identify the IP address
-	// 	timeInt = time.Now().Add(-30 * time.Second).Unix()
-	// }
-	// request.timeInt = timeInt
+	requestTime := parseRequestTime(request.Time)
 	data.RLock()
-	ipStruct, ok := data.ipToMac[request.IP]
+	line, ok := data.ipToMac[request.IP]
 	data.RUnlock()
-	if ok && time.Since(ipStruct.timeout) < (5*time.Minute) {
-		log.Tracef("IP:%v to MAC:%v, hostname:%v, comment:%v", ipStruct.IP, ipStruct.Mac, ipStruct.HostName, ipStruct.Comment)
-		response.Mac = ipStruct.Mac
-		response.IP = ipStruct.IP
-		response.HostName = ipStruct.HostName
-		response.Comments = ipStruct.Comment
-	} else if ok {
-		device := data.getInfoFromMTAboutIP(request.IP, &cfg)
-		data.updateInfoAboutIP(device)
-		log.Tracef("IP:%v to MAC:%v, hostname:%v, comment:%v", ipStruct.IP, ipStruct.Mac, ipStruct.HostName, ipStruct.Comment)
-		response.Mac = ipStruct.Mac
-		response.IP = ipStruct.IP
-		response.HostName = ipStruct.HostName
-		response.Comments = ipStruct.Comment
-		// } else if !ok {
-		// 	// TODO Make information about the mac-address loaded from the router
-		// 	log.Tracef("IP:'%v' not find in table lease of router:'%v'", ipStruct.IP, cfg.MTAddr)
-		// 	response.Mac = request.IP
-		// 	response.IP = request.IP
-	} else {
-		device := data.getInfoFromMTAboutIP(request.IP, &cfg)
-		data.updateInfoAboutIP(device)
-		log.Tracef("IP:'%v' not find in table lease of router:'%v'", ipStruct.IP, cfg.MTAddr)
-		if device.Mac == "" {
-			response.Mac = request.IP
-		}
-		response.Mac = device.Mac
-		response.IP = request.IP
+	if !ok || time.Since(line.timeout) >= 5*time.Minute {
+		line = data.updateInfoAboutIP(request.IP, requestTime)
 	}
+	data.resolvers.resolve(&line, requestTime, true)
+	response.fromLine(line)
 	if response.Mac == "" {
 		response.Mac = request.IP
 	}
-
+	log.Debugf("IP:%v to MAC:%v, login:%v, hostname:%v, comment:%v, source:%v", request.IP, response.Mac, response.Login, response.HostName, response.Comments, response.Source)
 	return response
 }
 
-func (data *Transport) getInfoFromMTAboutIP(ip string, cfg *Config) DeviceType {
-	device := DeviceType{}
-	device.IP = ip
-
-	reply2, err2 := data.clientROS.Run("/ip/dhcp-server/lease/print", "?active-address="+ip)
-	if err2 != nil {
-		log.Error(err2)
-	}
-	for _, re := range reply2.Re {
-		device.Id = re.Map[".id"]
-		// device.IP = re.Map["active-address"]
-		device.Mac = re.Map["mac-address"]
-		device.HostName = re.Map["host-name"]
-		device.Groups = re.Map["address-lists"]
-	}
-	if device.Mac == "" {
-		reply, err := data.clientROS.Run("/ip/arp/print", "?address="+ip)
-		if err != nil {
-			log.Error(err)
-		}
-		for _, re := range reply.Re {
-			device.Mac = re.Map["mac-address"]
-		}
-
-	}
-	log.Tracef("Get info from mikrotik ip(%v), device:%v\n", ip, device)
-	return device
-
-}
-
-func (data *Transport) updateInfoAboutIP(device DeviceType) {
-	data.RLock()
-	quotahourly := data.HourlyQuota
-	quotadaily := data.DailyQuota
-	quotamonthly := data.MonthlyQuota
-	data.RUnlock()
-
+// updateInfoAboutIP looks up the device in the chain of the sources and puts it into the table.
+func (data *Transport) updateInfoAboutIP(ip string, at time.Time) LineOfData {
+	quota := data.defaultQuota()
 	lineOfData := LineOfData{}
-	lineOfData.DeviceType = device
-	if lineOfData.HourlyQuota == 0 {
-		lineOfData.HourlyQuota = quotahourly
-	}
-	if lineOfData.DailyQuota == 0 {
-		lineOfData.DailyQuota = quotadaily
-	}
-	if lineOfData.MonthlyQuota == 0 {
-		lineOfData.MonthlyQuota = quotamonthly
-	}
+	lineOfData.IP = ip
+	lineOfData.setDefaultQuotas(quota)
 
+	data.resolvers.resolve(&lineOfData, at, false)
 	if lineOfData.Mac == "" {
 		lineOfData.Mac = lineOfData.IP
 	}
 	lineOfData.timeout = time.Now().In(data.Location)
+	if isIPv6(ip) && lineOfData.Mac != lineOfData.IP {
+		data.RLock()
+		linkToIPv4(&lineOfData, ipv4ByMac(data.ipToMac))
+		data.RUnlock()
+	}
+	data.wireless.apply(&lineOfData.DeviceType)
+	data.bridge.apply(&lineOfData.DeviceType)
+	data.directory.apply(&lineOfData)
+	data.identities.apply(&lineOfData, quota)
+	data.oui.apply(&lineOfData)
 
 	data.Lock()
-	data.ipToMac[device.IP] = lineOfData
+	data.ipToMac[ip] = lineOfData
 	data.Unlock()
+	return lineOfData
 }
 
 /*
@@ -222,36 +237,70 @@ func (data *Transport) loopGetDataFromMT() {
 	// }()
 	for {
 
+		ipToMac := data.getDataFromMT()
 		data.Lock()
-		data.ipToMac = data.getDataFromMT()
+		data.ipToMac = ipToMac
 		data.Unlock()
 
-		// ipToMac := data.getDataFromMT()
-		// data.Lock()
-		// data.ipToMac = ipToMac
-		// data.Unlock()
-		// ipToMac = map[string]LineOfData{}
-
 		interval, err := time.ParseDuration(cfg.Interval)
 		if err != nil {
 			interval = 10 * time.Minute
 		}
-		time.Sleep(interval)
+		// A router which has connected or reconnected is polled at once
+		select {
+		case <-time.After(interval):
+		case <-data.routerConnected:
+		}
 
 	}
 }
 
 func (data *Transport) getDataFromMT() map[string]LineOfData {
+	ipToMac := map[string]LineOfData{}
+	data.getDataFromRouters(ipToMac)
+	data.leaseFiles.mergeInto(ipToMac, data.QuotaType)
+	data.localNeighbors.mergeInto(ipToMac, data.QuotaType)
+	data.snmp.mergeInto(ipToMac, data.QuotaType)
+	if cfg.UseBridgeHost && data.connectedToMT() {
+		retention, err := time.ParseDuration(cfg.BridgeArpHistory)
+		if err != nil {
+			retention = 7 * 24 * time.Hour
+		}
+		data.bridge.remember(ipToMac, time.Now(), retention)
+		data.getBridgeHostsFromMT()
+		data.bridge.mergeInto(ipToMac, data.QuotaType)
+	}
+	if cfg.UsePPP && data.connectedToMT() {
+		data.getPPPFromMT()
+		data.ppp.mergeInto(ipToMac, data.QuotaType)
+	}
+	if cfg.UseWireGuard && data.connectedToMT() {
+		data.getWireGuardFromMT()
+		data.wireGuard.mergeInto(ipToMac, data.QuotaType)
+	}
+	data.hotSpot.mergeInto(ipToMac, data.QuotaType)
+	data.radius.mergeInto(ipToMac, data.QuotaType)
+	if cfg.UseIPv6 && data.connectedToMT() {
+		data.getIPv6FromMT(ipToMac, data.QuotaType)
+	}
+	data.wireless.mergeInto(ipToMac)
+	data.directory.mergeInto(ipToMac)
+	data.static.mergeInto(ipToMac, data.QuotaType)
+	data.identities.mergeInto(ipToMac, data.QuotaType)
+	data.oui.mergeInto(ipToMac)
+	return ipToMac
+}
+
+func (data *Transport) getArpAndLeasesFromMT(r *router, ipToMac map[string]LineOfData) error {
 
 	quotahourly := data.HourlyQuota
 	quotadaily := data.DailyQuota
 	quotamonthly := data.MonthlyQuota
 
 	lineOfData := LineOfData{}
-	ipToMac := map[string]LineOfData{}
-	reply, err := data.clientROS.Run("/ip/arp/print")
+	reply, err := r.client.Run("/ip/arp/print")
 	if err != nil {
-		log.Error(err)
+		return err
 	}
 	for _, re := range reply.Re {
 		lineOfData.IP = re.Map["address"]
@@ -268,9 +317,9 @@ func (data *Transport) getDataFromMT() map[string]LineOfData {
 		lineOfData.timeout = time.Now()
 		ipToMac[lineOfData.IP] = lineOfData
 	}
-	reply2, err2 := data.clientROS.Run("/ip/dhcp-server/lease/print") //, "?status=bound") //, "?disabled=false")
+	reply2, err2 := r.client.Run("/ip/dhcp-server/lease/print") //, "?status=bound") //, "?disabled=false")
 	if err2 != nil {
-		log.Error(err2)
+		return err2
 	}
 	for _, re := range reply2.Re {
 		lineOfData.Id = re.Map[".id"]
@@ -278,6 +327,7 @@ func (data *Transport) getDataFromMT() map[string]LineOfData {
 		lineOfData.Mac = re.Map["active-mac-address"]
 		// lineOfData.timeoutStr = re.Map["expires-after"]
 		lineOfData.HostName = re.Map["host-name"]
+		lineOfData.ClientID = re.Map["client-id"]
 		lineOfData.Comment = re.Map["comment"]
 		lineOfData.HourlyQuota, lineOfData.DailyQuota, lineOfData.MonthlyQuota, lineOfData.Name, lineOfData.Position, lineOfData.Company, lineOfData.TypeD = parseComments(lineOfData.Comment)
 		if lineOfData.HourlyQuota == 0 {
@@ -298,7 +348,7 @@ func (data *Transport) getDataFromMT() map[string]LineOfData {
 		ipToMac[lineOfData.IP] = lineOfData
 
 	}
-	return ipToMac
+	return nil
 }
 
 func parseComments(comment string) (
@@ -341,6 +391,80 @@ func parseComments(comment string) (
 
 }
 
+// applyComment fills the quotas and the person from a comment in the format of the lease comments.
+func (line *LineOfData) applyComment(comment string, quota QuotaType) {
+	line.Comment = comment
+	line.HourlyQuota, line.DailyQuota, line.MonthlyQuota, line.Name, line.Position, line.Company, line.TypeD = parseComments(comment)
+	line.setDefaultQuotas(quota)
+}
+
+func (line *LineOfData) setDefaultQuotas(quota QuotaType) {
+	if line.HourlyQuota == 0 {
+		line.HourlyQuota = quota.HourlyQuota
+	}
+	if line.DailyQuota == 0 {
+		line.DailyQuota = quota.DailyQuota
+	}
+	if line.MonthlyQuota == 0 {
+		line.MonthlyQuota = quota.MonthlyQuota
+	}
+}
+
+// mergeIPs puts the devices at the addresses known to a source into the table, the new ones with the default quotas.
+// The device without a MAC address gets its IP address instead.
+func mergeIPs(ipToMac map[string]LineOfData, ips []string, quota QuotaType, apply func(line *LineOfData) bool) {
+	for _, ip := range ips {
+		line, ok := ipToMac[ip]
+		if !ok {
+			line.IP = ip
+			line.setDefaultQuotas(quota)
+		}
+		if apply(&line) {
+			if line.Mac == "" {
+				line.Mac = line.IP
+			}
+			line.timeout = time.Now()
+			ipToMac[ip] = line
+		}
+	}
+}
+
+// parseROSDuration parses durations in the RouterOS format, e.g. 1w2d3h4m5s.
+func parseROSDuration(value string) time.Duration {
+	var result, number time.Duration
+	units := map[rune]time.Duration{
+		'w': 7 * 24 * time.Hour,
+		'd': 24 * time.Hour,
+		'h': time.Hour,
+		'm': time.Minute,
+		's': time.Second,
+	}
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			number = number*10 + time.Duration(r-'0')
+		case units[r] != 0:
+			result += number * units[r]
+			number = 0
+		default:
+			if duration, err := time.ParseDuration(value); err == nil {
+				return duration
+			}
+			return 0
+		}
+	}
+	return result
+}
+
+// parseRequestTime parses the unix time from the request. With an incorrect time the current time is used.
+func parseRequestTime(value string) time.Time {
+	timeInt, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+	return time.Unix(timeInt, 0)
+}
+
 func parseParamertToStr(inpuStr string) string {
 	Arr := strings.Split(inpuStr, "=")
 	if len(Arr) > 1 {
@@ -368,7 +492,8 @@ func parseParamertToUint(inputValue string) (quota uint64) {
 }
 
 func (transport *Transport) syncStatusDevices(inputSync map[string]bool) {
-	result := map[string]bool{}
+	type lease struct{ router, id string }
+	result := map[lease]bool{}
 	ipToMac := transport.getDataFromMT()
 	transport.Lock()
 	transport.ipToMac = ipToMac
@@ -377,20 +502,24 @@ func (transport *Transport) syncStatusDevices(inputSync map[string]bool) {
 	for _, value := range ipToMac {
 		for keySync := range inputSync {
 			if keySync == value.IP || keySync == value.Mac || keySync == value.HostName {
-				result[value.Id] = inputSync[keySync]
+				result[lease{value.Router, value.Id}] = inputSync[keySync]
 			}
 		}
 	}
 
 	for key := range result {
-		err := transport.setStatusDevice(key, result[key])
+		err := transport.setStatusDevice(transport.routerByName(key.router), key.id, result[key])
 		if err != nil {
 			log.Error(err)
 		}
 	}
 }
 
-func (data *Transport) setStatusDevice(number string, status bool) error {
+// setStatusDevice enables or disables the DHCP lease on the router owning the device.
+func (data *Transport) setStatusDevice(r *router, number string, status bool) error {
+	if r == nil {
+		return fmt.Errorf("the status of the device(%v) can only be set on the Mikrotik router", number)
+	}
 
 	var statusMtT string
 	if status {
@@ -399,7 +528,7 @@ func (data *Transport) setStatusDevice(number string, status bool) error {
 		statusMtT = "no"
 	}
 
-	reply, err := data.clientROS.Run("/ip/dhcp-server/lease/set", "=disabled="+statusMtT, "=numbers="+number)
+	reply, err := r.client.Run("/ip/dhcp-server/lease/set", "=disabled="+statusMtT, "=numbers="+number)
 	if err != nil {
 		return err
 	} else if reply.Done.Word != "!done" {
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -60,6 +62,8 @@ type decodedRecord struct {
 	Ipv4NextHop       string
 	SrcHostName       string
 	DstHostName       string
+	InputInterface    string
+	OutputInterface   string
 	Duration          uint16
 }
 
@@ -99,15 +103,12 @@ func (t *Transport) decodeRecordToSquid(record *decodedRecord, cfg *Config) (str
 	binRecord := record.binaryRecord
 	header := record.header
 	remoteAddr := record.Host
-	srcmacB := make([]byte, 8)
-	dstmacB := make([]byte, 8)
-	binary.BigEndian.PutUint16(srcmacB, binRecord.SrcAs)
-	binary.BigEndian.PutUint16(dstmacB, binRecord.DstAs)
-	// srcmac = srcmac[2:8]
-	// dstmac = dstmac[2:8]
 
 	var protocol, message, message2 string
 
+	record.InputInterface = t.interfaceName(record.Host, binRecord.InputSnmp)
+	record.OutputInterface = t.interfaceName(record.Host, binRecord.OutputSnmp)
+
 	switch fmt.Sprintf("%v", binRecord.Protocol) {
 	case "6":
 		protocol = "TCP_PACKET"
@@ -122,8 +123,16 @@ func (t *Transport) decodeRecordToSquid(record *decodedRecord, cfg *Config) (str
 
 	ok := cfg.CheckEntryInSubNet(intToIPv4Addr(binRecord.Ipv4DstAddrInt))
 	ok2 := cfg.CheckEntryInSubNet(intToIPv4Addr(binRecord.Ipv4SrcAddrInt))
+	if !ok && !ok2 && t.nat.translate(&binRecord) {
+		ok = cfg.CheckEntryInSubNet(intToIPv4Addr(binRecord.Ipv4DstAddrInt))
+		ok2 = cfg.CheckEntryInSubNet(intToIPv4Addr(binRecord.Ipv4SrcAddrInt))
+	}
 
 	if ok && !ok2 {
+		remote := t.remoteInfo(intToIPv4Addr(binRecord.Ipv4SrcAddrInt).String(), binRecord.SrcAs)
+		if !t.geoIP.allowed(remote.geoInfo) {
+			return "", ""
+		}
 		response := t.GetInfo(&request{
 			IP:   intToIPv4Addr(binRecord.Ipv4DstAddrInt).String(),
 			Time: fmt.Sprint(header.UnixSec)})
@@ -133,11 +142,10 @@ func (t *Transport) decodeRecordToSquid(record *decodedRecord, cfg *Config) (str
 			intToIPv4Addr(binRecord.Ipv4DstAddrInt).String(), // dst ip
 			protocol,          // protocol
 			binRecord.InBytes, // size
-			intToIPv4Addr(binRecord.Ipv4SrcAddrInt).String(), //src ip
+			t.remoteName(intToIPv4Addr(binRecord.Ipv4DstAddrInt).String(), intToIPv4Addr(binRecord.Ipv4SrcAddrInt).String()), //src ip or its name
 			binRecord.L4DstPort, // dstport
-			response.Mac,        // dstmac
+			response.userName(), // dstmac or login
 			remoteAddr,          // routerIP
-			// net.HardwareAddr(srcmacB).String(), // srcmac
 			binRecord.L4SrcPort, // src port
 			response.HostName,
 			response.Comments,
@@ -156,8 +164,14 @@ func (t *Transport) decodeRecordToSquid(record *decodedRecord, cfg *Config) (str
 			binRecord.L4SrcPort, // src port
 			response.Comments,
 		)
+		message += squidFields(cfg.SquidExtraFields, record, &response, &remote)
+		message2 += extraFields(cfg.ExtraFields, record, &response, &remote)
 
 	} else if !ok && ok2 {
+		remote := t.remoteInfo(intToIPv4Addr(binRecord.Ipv4DstAddrInt).String(), binRecord.DstAs)
+		if !t.geoIP.allowed(remote.geoInfo) {
+			return "", ""
+		}
 		response := t.GetInfo(&request{
 			IP:   intToIPv4Addr(binRecord.Ipv4SrcAddrInt).String(),
 			Time: fmt.Sprint(header.UnixSec)})
@@ -167,11 +181,10 @@ func (t *Transport) decodeRecordToSquid(record *decodedRecord, cfg *Config) (str
 			intToIPv4Addr(binRecord.Ipv4SrcAddrInt).String(), //src ip - Local
 			protocol,          // protocol
 			binRecord.InBytes, // size
-			intToIPv4Addr(binRecord.Ipv4DstAddrInt).String(), // dst ip - Inet
+			t.remoteName(intToIPv4Addr(binRecord.Ipv4SrcAddrInt).String(), intToIPv4Addr(binRecord.Ipv4DstAddrInt).String()), // dst ip - Inet or its name
 			binRecord.L4DstPort, // dstport
-			response.Mac,        // dstmac
+			response.userName(), // dstmac or login
 			remoteAddr,          // routerIP
-			// net.HardwareAddr(srcmacB).String(), // srcmac
 			binRecord.L4SrcPort, // src port
 			response.HostName,
 			response.Comments,
@@ -190,11 +203,96 @@ func (t *Transport) decodeRecordToSquid(record *decodedRecord, cfg *Config) (str
 			binRecord.L4DstPort, // dstport  (reverses src port)
 			response.Comments,
 		)
+		message += squidFields(cfg.SquidExtraFields, record, &response, &remote)
+		message2 += extraFields(cfg.ExtraFields, record, &response, &remote)
 
 	}
 	return message, message2
 }
 
+// remoteInfo is what is known about the remote address of a flow.
+type remoteInfo struct {
+	geoInfo
+	asInfo
+}
+
+// remoteInfo looks up the remote address, exporterAS is the AS number from the flow, 0 if the exporter does not fill it.
+func (t *Transport) remoteInfo(ip string, exporterAS uint16) remoteInfo {
+	return remoteInfo{geoInfo: t.geoIP.lookup(ip), asInfo: t.asnDB.remoteAS(ip, exporterAS)}
+}
+
+// extraValues returns the values of the additional fields of the line.
+func extraValues(fields []string, record *decodedRecord, response *ResponseType, remote *remoteInfo) []string {
+	values := []string{}
+	for _, field := range fields {
+		var value string
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "":
+			continue
+		case "ssid":
+			value = response.SSID
+		case "ap":
+			value = response.AP
+		case "signal":
+			value = response.Signal
+		case "roams":
+			value = strconv.Itoa(response.Roams)
+		case "bridge_port":
+			value = response.BridgePort
+		case "vlan":
+			value = response.VLAN
+		case "interface":
+			value = response.Interface
+		case "vendor":
+			value = response.Vendor
+		case "device_id":
+			value = response.DeviceID
+		case "input_interface":
+			value = record.InputInterface
+		case "output_interface":
+			value = record.OutputInterface
+		case "country":
+			value = remote.Country
+		case "city":
+			value = remote.City
+		case "asn":
+			if remote.ASN != 0 {
+				value = strconv.FormatUint(uint64(remote.ASN), 10)
+			}
+		case "as_org":
+			value = remote.ASOrg
+		default:
+			log.Tracef("Unknown additional field:%v", field)
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// extraFields returns the additional fields for the line of csv, each one preceded by a comma.
+func extraFields(fields []string, record *decodedRecord, response *ResponseType, remote *remoteInfo) string {
+	var result strings.Builder
+	for _, value := range extraValues(fields, record, response, remote) {
+		// The values like "Cloudflare, Inc." must not shift the columns
+		result.WriteString("," + strings.ReplaceAll(value, ",", ""))
+	}
+	return result.String()
+}
+
+// squidFields returns the additional fields for the line of the squid log, each one preceded by a space.
+// The spaces inside the values are replaced with _, the empty values are written as -.
+func squidFields(fields []string, record *decodedRecord, response *ResponseType, remote *remoteInfo) string {
+	var result strings.Builder
+	for _, value := range extraValues(fields, record, response, remote) {
+		value = strings.Join(strings.Fields(value), "_")
+		if value == "" {
+			value = "-"
+		}
+		result.WriteString(" " + value)
+	}
+	return result.String()
+}
+
 func (cfg *Config) CheckEntryInSubNet(ipv4addr net.IP) bool {
 	for _, subNet := range cfg.SubNets {
 		ok, err := checkIP(subNet, ipv4addr)
@@ -225,7 +323,7 @@ func (data *Transport) pipeOutputToStdoutForSquid(outputChannel chan decodedReco
 		log.Tracef("Get from outputChannel:%v", record)
 		message, csvMessage := data.decodeRecordToSquid(&record, cfg)
 		log.Tracef("Decoded record (%v) to message (%v)", record, message)
-		message = filtredMessage(message, cfg.IgnorList)
+		message = filtredMessage(message, &record, cfg.IgnorList)
 		if message == "" {
 			continue
 		}
@@ -244,9 +342,9 @@ func (data *Transport) pipeOutputToStdoutForSquid(outputChannel chan decodedReco
 	}
 }
 
-func filtredMessage(message string, IgnorList []string) string {
+func filtredMessage(message string, record *decodedRecord, IgnorList []string) string {
 	for _, ignorStr := range IgnorList {
-		if strings.Contains(message, ignorStr) {
+		if ignoredInterface(record, ignorStr) || strings.Contains(message, ignorStr) {
 			log.Tracef("Line of log :%v contains ignorstr:%v, skipping...", message, ignorStr)
 			return ""
 		}
@@ -254,13 +352,65 @@ func filtredMessage(message string, IgnorList []string) string {
 	return message
 }
 
+// ignoredInterface reports whether the flow goes through the interface of in:NAME, out:NAME or iface:NAME.
+func ignoredInterface(record *decodedRecord, ignorStr string) bool {
+	arr := strings.SplitN(ignorStr, ":", 2)
+	if len(arr) != 2 {
+		return false
+	}
+	switch arr[0] {
+	case "in":
+		return strings.EqualFold(record.InputInterface, arr[1])
+	case "out":
+		return strings.EqualFold(record.OutputInterface, arr[1])
+	case "iface":
+		return strings.EqualFold(record.InputInterface, arr[1]) || strings.EqualFold(record.OutputInterface, arr[1])
+	}
+	return false
+}
+
+// listFilter is the list of the values written to the log, all if it is empty, the values prefixed with ! are excluded.
+type listFilter struct {
+	include map[string]bool
+	exclude map[string]bool
+}
+
+func newListFilter(list []string) listFilter {
+	f := listFilter{include: map[string]bool{}, exclude: map[string]bool{}}
+	for _, value := range list {
+		value = strings.ToLower(strings.TrimSpace(value))
+		switch {
+		case value == "" || value == "!":
+		case strings.HasPrefix(value, "!"):
+			f.exclude[value[1:]] = true
+		default:
+			f.include[value] = true
+		}
+	}
+	return f
+}
+
+// allowed reports whether the record with the values is written: none of them is excluded and any is included.
+func (f listFilter) allowed(values ...string) bool {
+	included := len(f.include) == 0
+	for _, value := range values {
+		value = strings.ToLower(value)
+		if f.exclude[value] {
+			return false
+		}
+		included = included || f.include[value]
+	}
+	return included
+}
+
 type cacheRecord struct {
 	Hostname string
-	// timeout  time.Time
+	timeout  time.Time
 }
 
 type Cache struct {
 	cache map[string]cacheRecord
+	queue chan string
 	sync.RWMutex
 }
 
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type directoryRecord struct {
+	person  PersonType
+	found   bool
+	expires time.Time
+}
+
+// Directory fills the owners of the devices from LDAP or Active Directory. The owner is looked up
+// by the hostname, the MAC address, the login or a key of the lease comment.
+type Directory struct {
+	lookup     string
+	attributes map[string]string
+	cache      map[string]directoryRecord
+	sync.RWMutex
+}
+
+func newDirectory(lookup string, attributes []string) *Directory {
+	d := &Directory{lookup: lookup, attributes: map[string]string{}, cache: map[string]directoryRecord{}}
+	for _, value := range attributes {
+		if value == "" {
+			continue
+		}
+		arr := strings.SplitN(value, "=", 2)
+		if len(arr) != 2 {
+			log.Errorf("Error parse LDAP attribute(%v), the format is field=attribute, field is name, position, company or department", value)
+			continue
+		}
+		switch arr[0] {
+		case "name", "position", "company", "department":
+			d.attributes[arr[0]] = arr[1]
+		default:
+			log.Errorf("Error parse LDAP attribute(%v), field is name, position, company or department", value)
+		}
+	}
+	return d
+}
+
+// key returns the value by which the owner of the device is looked up.
+func (d *Directory) key(line *LineOfData) string {
+	switch d.lookup {
+	case "hostname":
+		return line.HostName
+	case "mac":
+		if line.Mac == line.IP {
+			return ""
+		}
+		return line.Mac
+	case "login":
+		return line.Login
+	}
+	for _, value := range strings.Split(line.Comment, "/") {
+		arr := strings.SplitN(value, "=", 2)
+		if len(arr) == 2 && arr[0] == d.lookup {
+			return arr[1]
+		}
+	}
+	return ""
+}
+
+func (data *Transport) loopLookupDirectory() {
+	for {
+		keys := map[string]bool{}
+		now := time.Now()
+		data.RLock()
+		for _, line := range data.ipToMac {
+			if key := data.directory.key(&line); key != "" {
+				keys[key] = true
+			}
+		}
+		data.RUnlock()
+
+		data.directory.RLock()
+		for key := range keys {
+			if record, ok := data.directory.cache[key]; ok && now.Before(record.expires) {
+				delete(keys, key)
+			}
+		}
+		data.directory.RUnlock()
+
+		if len(keys) > 0 {
+			if err := data.directory.lookupAll(keys); err != nil {
+				log.Errorf("Error getting owners of devices from LDAP(%v):%v", cfg.LDAPURL, err)
+			}
+			data.Lock()
+			data.directory.mergeInto(data.ipToMac)
+			data.Unlock()
+		}
+
+		interval, err := time.ParseDuration(cfg.LDAPInterval)
+		if err != nil {
+			interval = time.Minute
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (d *Directory) lookupAll(keys map[string]bool) error {
+	timeout, err := time.ParseDuration(cfg.LDAPTimeout)
+	if err != nil {
+		timeout = 5 * time.Second
+	}
+	ttl := parseDurationOr(cfg.LDAPCacheTTL, time.Hour)
+	negativeTTL := parseDurationOr(cfg.LDAPNegativeTTL, 10*time.Minute)
+	conn, err := dialLDAP(cfg.LDAPURL, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if cfg.LDAPBindDN != "" {
+		if err := conn.bind(cfg.LDAPBindDN, cfg.LDAPBindPassword); err != nil {
+			return err
+		}
+	}
+
+	attributes := []string{}
+	for _, attr := range d.attributes {
+		attributes = append(attributes, attr)
+	}
+	for key := range keys {
+		person, found, err := d.lookupOwner(conn, key, attributes)
+		expires := time.Now().Add(ttl)
+		if err != nil || !found {
+			// The devices without an owner are not looked up on every interval
+			expires = time.Now().Add(negativeTTL)
+		}
+		d.Lock()
+		d.cache[key] = directoryRecord{person: person, found: found && err == nil, expires: expires}
+		d.Unlock()
+		if _, ok := err.(*ldapResultError); ok {
+			log.Errorf("Error getting the owner of %v from LDAP:%v", key, err)
+			continue
+		} else if err != nil {
+			// The connection is lost, the other keys are looked up on the next interval
+			return err
+		}
+		log.Tracef("The owner of %v from LDAP:%#v", key, person)
+	}
+	return nil
+}
+
+func (d *Directory) lookupOwner(conn *ldapConn, key string, attributes []string) (PersonType, bool, error) {
+	filter := strings.ReplaceAll(cfg.LDAPFilter, "{value}", ldapEscape(key))
+	searchAttributes := attributes
+	if cfg.LDAPOwnerAttribute != "" {
+		searchAttributes = []string{cfg.LDAPOwnerAttribute}
+	}
+	// Only the first entry found is used
+	entries, err := conn.search(cfg.LDAPBaseDN, ldapScopeSubtree, filter, searchAttributes, 1)
+	if err != nil || len(entries) == 0 {
+		return PersonType{}, false, err
+	}
+	entry := entries[0]
+	if cfg.LDAPOwnerAttribute != "" {
+		// The found entry is e.g. a computer, the owner is the entry by the DN in its attribute (managedBy)
+		ownerDN := entry.get(cfg.LDAPOwnerAttribute)
+		if ownerDN == "" {
+			return PersonType{}, false, nil
+		}
+		entries, err = conn.search(ownerDN, ldapScopeBase, "(objectClass=*)", attributes, 1)
+		if err != nil || len(entries) == 0 {
+			return PersonType{}, false, err
+		}
+		entry = entries[0]
+	}
+	return PersonType{
+		Name:       entry.get(d.attributes["name"]),
+		Position:   entry.get(d.attributes["position"]),
+		Company:    entry.get(d.attributes["company"]),
+		Department: entry.get(d.attributes["department"]),
+	}, true, nil
+}
+
+// apply puts the owner from the cache into the line, the data of the directory override the lease comments.
+func (d *Directory) apply(line *LineOfData) bool {
+	key := d.key(line)
+	if key == "" {
+		return false
+	}
+	d.RLock()
+	record, ok := d.cache[key]
+	d.RUnlock()
+	if !ok || !record.found {
+		return false
+	}
+	if record.person.Name != "" {
+		line.Name = record.person.Name
+	}
+	if record.person.Position != "" {
+		line.Position = record.person.Position
+	}
+	if record.person.Company != "" {
+		line.Company = record.person.Company
+	}
+	if record.person.Department != "" {
+		line.Department = record.person.Department
+	}
+	return true
+}
+
+func (d *Directory) mergeInto(ipToMac map[string]LineOfData) {
+	for ip, line := range ipToMac {
+		if d.apply(&line) {
+			ipToMac[ip] = line
+		}
+	}
+}
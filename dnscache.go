@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type dnsName struct {
+	name string
+	seen time.Time
+}
+
+// DNSCache is the index of the names resolved by the clients through the DNS server of the router.
+type DNSCache struct {
+	names map[string]dnsName
+	sync.RWMutex
+}
+
+func (data *Transport) loopGetDNSCacheFromMT() {
+	for {
+		data.getDNSCacheFromMT()
+
+		interval, err := time.ParseDuration(cfg.DNSCacheInterval)
+		if err != nil {
+			interval = time.Minute
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (data *Transport) getDNSCacheFromMT() {
+	type record struct {
+		name string
+		ttl  time.Duration
+	}
+	addresses := map[string]record{}
+	cnames := map[string]string{}
+	data.pollRouters("the DNS cache", func(r *router) error {
+		reply, err := r.client.Run("/ip/dns/cache/all/print")
+		if err != nil {
+			return err
+		}
+		for _, re := range reply.Re {
+			name := strings.TrimSuffix(re.Map["name"], ".")
+			value := strings.TrimSuffix(re.Map["data"], ".")
+			switch re.Map["type"] {
+			case "A", "AAAA":
+				ttl := parseROSDuration(re.Map["ttl"])
+				// The address of several names is given to the one resolved last, i.e. with the longest TTL left
+				if r, ok := addresses[value]; !ok || ttl > r.ttl {
+					addresses[value] = record{name, ttl}
+				}
+			case "CNAME":
+				cnames[value] = name
+			}
+		}
+		return nil
+	})
+
+	now := time.Now()
+	history, err := time.ParseDuration(cfg.DNSCacheHistory)
+	if err != nil {
+		history = time.Hour
+	}
+	data.dnsCache.Lock()
+	defer data.dnsCache.Unlock()
+	if data.dnsCache.names == nil {
+		data.dnsCache.names = map[string]dnsName{}
+	}
+	for ip, r := range addresses {
+		data.dnsCache.names[ip] = dnsName{name: requestedName(r.name, cnames), seen: now}
+	}
+	for ip, n := range data.dnsCache.names {
+		if now.Sub(n.seen) > history {
+			delete(data.dnsCache.names, ip)
+		}
+	}
+	log.Debugf("Get %v addresses from the DNS cache", len(addresses))
+}
+
+// requestedName follows the CNAME records back to the name requested by the client,
+// e.g. www.example.com instead of the name of its CDN.
+func requestedName(name string, cnames map[string]string) string {
+	for i := 0; i < 8; i++ {
+		alias, ok := cnames[name]
+		if !ok {
+			break
+		}
+		name = alias
+	}
+	return name
+}
+
+func (d *DNSCache) name(ip string) (string, bool) {
+	d.RLock()
+	defer d.RUnlock()
+	n, ok := d.names[ip]
+	return n.name, ok
+}
+
+// remoteName returns the name for the URL field of the squid log: the name the client resolved from dnstap,
+// the name from the DNS cache of the router, the name from reverse DNS or the IP address itself.
+func (data *Transport) remoteName(client, ip string) string {
+	if name, ok := data.dnsTap.name(client, ip); ok {
+		return name
+	}
+	if name, ok := data.dnsCache.name(ip); ok {
+		return name
+	}
+	return cache.hostname(ip)
+}
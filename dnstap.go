@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// dnstap receiver: Frame Streams (bidirectional and unidirectional) carrying protobuf dnstap messages.
+
+const (
+	fstrmControlAccept = 0x01
+	fstrmControlStart  = 0x02
+	fstrmControlStop   = 0x03
+	fstrmControlReady  = 0x04
+	fstrmControlFinish = 0x05
+
+	fstrmFieldContentType = 0x01
+	dnstapContentType     = "protobuf:dnstap.Dnstap"
+	// A dnstap message is a DNS query or response with a few fields, far less than this
+	fstrmMaxFrameLength = 256 << 10
+
+	dnstapClientResponse = 6
+
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+)
+
+// DNSTap is the index of the names resolved by the clients, by the client and the answered address.
+type DNSTap struct {
+	clients map[string]map[string]dnsName
+	sync.RWMutex
+}
+
+func (data *Transport) listenDNSTap() {
+	network, address := "tcp", cfg.DNSTapAddr
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+		if err := removeSocket(address); err != nil {
+			log.Errorf("Error listening dnstap on %v:%v", address, err)
+			return
+		}
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		log.Errorf("Error listening dnstap on %v:%v", address, err)
+		return
+	}
+	log.Infof("Listening dnstap on %v", address)
+	go data.loopExpireDNSTap()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Errorf("Error accepting a dnstap connection:%v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			if err := data.dnsTap.readFrameStream(conn, conn); err != nil && err != io.EOF {
+				log.Errorf("Error reading dnstap from %v:%v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func (data *Transport) loopExpireDNSTap() {
+	for {
+		time.Sleep(time.Minute)
+		history, err := time.ParseDuration(cfg.DNSTapHistory)
+		if err != nil {
+			history = time.Hour
+		}
+		now := time.Now()
+		data.dnsTap.Lock()
+		for client, names := range data.dnsTap.clients {
+			for ip, n := range names {
+				if now.Sub(n.seen) > history {
+					delete(names, ip)
+				}
+			}
+			if len(names) == 0 {
+				delete(data.dnsTap.clients, client)
+			}
+		}
+		data.dnsTap.Unlock()
+	}
+}
+
+func (d *DNSTap) readFrameStream(r io.Reader, w io.Writer) error {
+	reader := bufio.NewReader(r)
+	for {
+		var length uint32
+		if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+			return err
+		}
+		if length > fstrmMaxFrameLength {
+			return fmt.Errorf("wrong length of data frame: %v", length)
+		}
+		if length != 0 {
+			frame := make([]byte, length)
+			if _, err := io.ReadFull(reader, frame); err != nil {
+				return err
+			}
+			if err := d.handleDnstap(frame); err != nil {
+				log.Tracef("Error parse dnstap message:%v", err)
+			}
+			continue
+		}
+
+		// A control frame: the escape, the length and the type
+		if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+			return err
+		}
+		if length < 4 || length > 512 {
+			return fmt.Errorf("wrong length of control frame: %v", length)
+		}
+		control := make([]byte, length)
+		if _, err := io.ReadFull(reader, control); err != nil {
+			return err
+		}
+		switch binary.BigEndian.Uint32(control) {
+		case fstrmControlReady:
+			if err := writeControlFrame(w, fstrmControlAccept, dnstapContentType); err != nil {
+				return err
+			}
+		case fstrmControlStart:
+		case fstrmControlStop:
+			writeControlFrame(w, fstrmControlFinish, "")
+			return nil
+		}
+	}
+}
+
+// removeSocket removes the socket left by the previous run, any other file is kept.
+func removeSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%v exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
+
+func writeControlFrame(w io.Writer, controlType uint32, contentType string) error {
+	control := make([]byte, 4)
+	binary.BigEndian.PutUint32(control, controlType)
+	if contentType != "" {
+		field := make([]byte, 8)
+		binary.BigEndian.PutUint32(field, fstrmFieldContentType)
+		binary.BigEndian.PutUint32(field[4:], uint32(len(contentType)))
+		control = append(append(control, field...), contentType...)
+	}
+	frame := make([]byte, 8)
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(control)))
+	_, err := w.Write(append(frame, control...))
+	return err
+}
+
+// handleDnstap records the answers of CLIENT_RESPONSE messages.
+func (d *DNSTap) handleDnstap(frame []byte) error {
+	var message []byte
+	err := protoFields(frame, func(field int, _ uint64, value []byte) {
+		if field == 14 {
+			message = value
+		}
+	})
+	if err != nil || message == nil {
+		return err
+	}
+	var messageType uint64
+	var client, response []byte
+	err = protoFields(message, func(field int, number uint64, value []byte) {
+		switch field {
+		case 1:
+			messageType = number
+		case 4:
+			client = value
+		case 14:
+			response = value
+		}
+	})
+	if err != nil || messageType != dnstapClientResponse || len(client) == 0 || response == nil {
+		return err
+	}
+	name, addresses, err := parseDNSAnswers(response)
+	if err != nil || len(addresses) == 0 {
+		return err
+	}
+
+	clientIP := net.IP(client).String()
+	now := time.Now()
+	d.Lock()
+	defer d.Unlock()
+	if d.clients == nil {
+		d.clients = map[string]map[string]dnsName{}
+	}
+	names, ok := d.clients[clientIP]
+	if !ok {
+		names = map[string]dnsName{}
+		d.clients[clientIP] = names
+	}
+	for _, address := range addresses {
+		names[address] = dnsName{name: name, seen: now}
+	}
+	return nil
+}
+
+func (d *DNSTap) name(client, ip string) (string, bool) {
+	d.RLock()
+	defer d.RUnlock()
+	n, ok := d.clients[client][ip]
+	return n.name, ok
+}
+
+// protoFields calls fn for every field of a protobuf message, number is the value of the varint and fixed fields.
+func protoFields(b []byte, fn func(field int, number uint64, value []byte)) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("protobuf: wrong key")
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errors.New("protobuf: wrong varint")
+			}
+			fn(field, v, nil)
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return errors.New("protobuf: short fixed64")
+			}
+			fn(field, binary.LittleEndian.Uint64(b), nil)
+			b = b[8:]
+		case 2:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return errors.New("protobuf: wrong length")
+			}
+			fn(field, 0, b[n:n+int(length)])
+			b = b[n+int(length):]
+		case 5:
+			if len(b) < 4 {
+				return errors.New("protobuf: short fixed32")
+			}
+			fn(field, uint64(binary.LittleEndian.Uint32(b)), nil)
+			b = b[4:]
+		default:
+			return fmt.Errorf("protobuf: unsupported wire type %v", key&7)
+		}
+	}
+	return nil
+}
+
+// parseDNSAnswers returns the name of the question and the addresses of the answer section.
+func parseDNSAnswers(msg []byte) (string, []string, error) {
+	if len(msg) < 12 {
+		return "", nil, errors.New("dns: short message")
+	}
+	qdCount := binary.BigEndian.Uint16(msg[4:6])
+	anCount := binary.BigEndian.Uint16(msg[6:8])
+	if qdCount == 0 {
+		return "", nil, errors.New("dns: no question")
+	}
+	offset := 12
+	var question string
+	for i := 0; i < int(qdCount); i++ {
+		name, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return "", nil, err
+		}
+		if i == 0 {
+			question = name
+		}
+		// QTYPE and QCLASS
+		if offset = next + 4; offset > len(msg) {
+			return "", nil, errors.New("dns: short question")
+		}
+	}
+	addresses := []string{}
+	for i := 0; i < int(anCount); i++ {
+		_, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return "", nil, err
+		}
+		if next+10 > len(msg) {
+			return "", nil, errors.New("dns: short answer")
+		}
+		rrType := binary.BigEndian.Uint16(msg[next:])
+		rdLength := int(binary.BigEndian.Uint16(msg[next+8:]))
+		rdata := next + 10
+		if rdata+rdLength > len(msg) {
+			return "", nil, errors.New("dns: short rdata")
+		}
+		if (rrType == dnsTypeA && rdLength == 4) || (rrType == dnsTypeAAAA && rdLength == 16) {
+			addresses = append(addresses, net.IP(msg[rdata:rdata+rdLength]).String())
+		}
+		offset = rdata + rdLength
+	}
+	return question, addresses, nil
+}
+
+// readDNSName reads the possibly compressed name and returns the offset right after it.
+func readDNSName(msg []byte, offset int) (string, int, error) {
+	labels := []string{}
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errors.New("dns: short name")
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(msg) || jumps > 16 {
+				return "", 0, errors.New("dns: wrong pointer")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3fff)
+			jumps++
+		case length&0xc0 != 0:
+			return "", 0, errors.New("dns: unsupported label type")
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errors.New("dns: short label")
+			}
+			labels = append(labels, strings.ToLower(string(msg[offset+1:offset+1+length])))
+			offset += 1 + length
+		}
+	}
+}
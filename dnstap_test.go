@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadFrameStream(t *testing.T) {
+	control := func(controlType uint32) []byte {
+		var b bytes.Buffer
+		writeControlFrame(&b, controlType, dnstapContentType)
+		return b.Bytes()
+	}
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, 3)
+	stream := append(append(append(control(fstrmControlReady), data...), 1, 2, 3), control(fstrmControlStop)...)
+
+	d := &DNSTap{clients: map[string]map[string]dnsName{}}
+	var answer bytes.Buffer
+	if err := d.readFrameStream(bytes.NewReader(stream), &answer); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(answer.Bytes(), []byte(dnstapContentType)) {
+		t.Errorf("no Accept in %v", answer.Bytes())
+	}
+
+	// The length of a data frame is checked before the frame is allocated
+	binary.BigEndian.PutUint32(data, 0xffffff00)
+	if err := d.readFrameStream(bytes.NewReader(data), &answer); err == nil {
+		t.Error("no error with a frame of 4 GiB")
+	}
+}
+
+func TestRemoveSocket(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "dnstap.sock")
+	if err := ioutil.WriteFile(file, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeSocket(file); err == nil {
+		t.Error("a regular file is removed")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Error(err)
+	}
+	os.Remove(file)
+
+	ln, err := net.Listen("unix", file)
+	if err != nil {
+		t.Skip(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	if err := removeSocket(file); err != nil {
+		t.Error(err)
+	}
+	if err := removeSocket(file); err != nil {
+		t.Errorf("missing socket: %v", err)
+	}
+}
+
+func uvarint(values ...uint64) []byte {
+	b := []byte{}
+	for _, v := range values {
+		buf := make([]byte, binary.MaxVarintLen64)
+		b = append(b, buf[:binary.PutUvarint(buf, v)]...)
+	}
+	return b
+}
+
+func protoField(field int, value []byte) []byte {
+	return append(uvarint(uint64(field)<<3|2, uint64(len(value))), value...)
+}
+
+func protoVarint(field int, value uint64) []byte {
+	return uvarint(uint64(field)<<3, value)
+}
+
+// dnstapFrame is the Dnstap message of the type with the address of the client and the DNS response.
+func dnstapFrame(messageType uint64, client net.IP, response []byte) []byte {
+	message := append(protoVarint(1, messageType), protoField(4, client)...)
+	message = append(message, protoField(14, response)...)
+	return append(protoField(1, []byte("unbound")), protoField(14, message)...)
+}
+
+func dnsLabels(name string) []byte {
+	b := []byte{}
+	for _, label := range strings.Split(name, ".") {
+		b = append(append(b, byte(len(label))), label...)
+	}
+	return append(b, 0)
+}
+
+func dnsRR(name []byte, rrType uint16, rdata []byte) []byte {
+	b := append([]byte{}, name...)
+	b = append(b, byte(rrType>>8), byte(rrType), 0, 1, 0, 0, 0x0e, 0x10, byte(len(rdata)>>8), byte(len(rdata)))
+	return append(b, rdata...)
+}
+
+// testDNSResponse answers www.example.com with the CNAME cdn.example.net and its addresses, the names are compressed.
+func testDNSResponse() []byte {
+	msg := []byte{0x12, 0x34, 0x81, 0x80, 0, 1, 0, 3, 0, 0, 0, 0}
+	msg = append(append(msg, dnsLabels("www.Example.com")...), 0, 1, 0, 1)
+	// The CNAME points to the question, the addresses point to the name in the rdata of the CNAME
+	cname := len(msg) + 12
+	msg = append(msg, dnsRR([]byte{0xc0, 12}, 5, dnsLabels("cdn.example.net"))...)
+	pointer := []byte{0xc0 | byte(cname>>8), byte(cname)}
+	msg = append(msg, dnsRR(pointer, dnsTypeA, net.ParseIP("192.0.2.1").To4())...)
+	return append(msg, dnsRR(pointer, dnsTypeAAAA, net.ParseIP("2001:db8::1"))...)
+}
+
+func TestParseDNSAnswers(t *testing.T) {
+	name, addresses, err := parseDNSAnswers(testDNSResponse())
+	if err != nil || name != "www.example.com" || fmt.Sprint(addresses) != "[192.0.2.1 2001:db8::1]" {
+		t.Errorf("%v %v %v", name, addresses, err)
+	}
+
+	question := append(dnsLabels("a.example"), 0, 1, 0, 1)
+	header := func(anCount byte) []byte { return []byte{0, 0, 0x81, 0x80, 0, 1, 0, anCount, 0, 0, 0, 0} }
+	broken := map[string][]byte{
+		"no question":       {0, 0, 0x81, 0x80, 0, 0, 0, 0, 0, 0, 0, 0},
+		"short question":    append(header(0), dnsLabels("a.example")...),
+		"pointer to itself": append(header(1), append(question, dnsRR([]byte{0xc0, byte(12 + len(question))}, dnsTypeA, []byte{1, 2, 3, 4})...)...),
+		"pointer loop":      append(header(0), 1, 'a', 0xc0, 12, 0, 1, 0, 1),
+		"pointer outside":   append(header(0), 0xc0, 0xff, 0, 1, 0, 1),
+		"label over end":    append(header(0), 9, 'a'),
+		"extended label":    append(header(0), 0x41, 'a', 0, 0, 1, 0, 1),
+		"short answer":      append(append(header(1), question...), 0xc0, 12, 0, 1),
+		"short rdata":       append(append(header(1), question...), dnsRR([]byte{0xc0, 12}, dnsTypeA, []byte{1, 2, 3, 4})[:14]...),
+		"more answers":      append(append(header(2), question...), dnsRR([]byte{0xc0, 12}, dnsTypeA, []byte{1, 2, 3, 4})...),
+	}
+	for name, msg := range broken {
+		if _, _, err := parseDNSAnswers(msg); err == nil {
+			t.Errorf("%v: no error", name)
+		}
+	}
+}
+
+func TestHandleDnstap(t *testing.T) {
+	d := &DNSTap{}
+	client := net.ParseIP("10.0.0.5").To4()
+	if err := d.handleDnstap(dnstapFrame(5, client, testDNSResponse())); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.name("10.0.0.5", "192.0.2.1"); ok {
+		t.Error("the answer of a CLIENT_QUERY message is recorded")
+	}
+	if err := d.handleDnstap(dnstapFrame(dnstapClientResponse, client, testDNSResponse())); err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"192.0.2.1", "2001:db8::1"} {
+		if name, ok := d.name("10.0.0.5", ip); !ok || name != "www.example.com" {
+			t.Errorf("%v: %v %v", ip, name, ok)
+		}
+	}
+
+	broken := map[string][]byte{
+		"wrong key":          {0x80},
+		"wrong varint":       {0x08, 0xff},
+		"length over end":    {0x72, 0x10, 1, 2},
+		"huge length":        {0x72, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+		"short fixed64":      {0x09, 1, 2},
+		"short fixed32":      {0x0d, 1},
+		"unknown wire type":  {0x0b},
+		"broken dns message": dnstapFrame(dnstapClientResponse, client, []byte{0, 0, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0, 0xc0}),
+	}
+	for name, frame := range broken {
+		if err := d.handleDnstap(frame); err == nil {
+			t.Errorf("%v: no error", name)
+		}
+	}
+}
+
+// The frames come from the socket, so no truncated or damaged frame may panic.
+func TestHandleDnstapDamaged(t *testing.T) {
+	d := &DNSTap{}
+	frame := dnstapFrame(dnstapClientResponse, net.ParseIP("10.0.0.5").To4(), testDNSResponse())
+	handle := func(b []byte) {
+		defer func() {
+			if e := recover(); e != nil {
+				t.Fatalf("panic with % x: %v", b, e)
+			}
+		}()
+		d.handleDnstap(b)
+		parseDNSAnswers(b)
+	}
+	for i := range frame {
+		handle(frame[:i])
+	}
+	response := testDNSResponse()
+	for i := range response {
+		handle(response[:i])
+		handle(dnstapFrame(dnstapClientResponse, net.ParseIP("10.0.0.5").To4(), response[:i]))
+	}
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		damaged := append([]byte{}, response...)
+		for n := random.Intn(4) + 1; n > 0; n-- {
+			damaged[random.Intn(len(damaged))] = byte(random.Intn(256))
+		}
+		handle(damaged)
+		handle(dnstapFrame(dnstapClientResponse, net.ParseIP("10.0.0.5").To4(), damaged))
+	}
+}
@@ -0,0 +1,67 @@
+//go:build ignore
+// +build ignore
+
+// gen_oui converts the file made by -oui_update into oui_registry.go, the registry bundled into the program:
+//
+//	go run gen_oui.go oui.txt
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: go run gen_oui.go <file made by -oui_update>")
+	}
+	f, err := os.Open(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	vendors := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		arr := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(arr) != 2 || arr[0] == "" || strings.TrimSpace(arr[1]) == "" {
+			continue
+		}
+		vendors[arr[0]] = strings.Join(strings.Fields(arr[1]), " ")
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+	if len(vendors) == 0 {
+		log.Fatalf("no vendors found in %v", os.Args[1])
+	}
+
+	prefixes := make([]string, 0, len(vendors))
+	for prefix := range vendors {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	var b bytes.Buffer
+	b.WriteString("// Code generated by gen_oui.go; DO NOT EDIT.\n\npackage main\n\n")
+	b.WriteString("// registryOUI is the IEEE registry bundled into the program, a newer one is read from -oui_file.\n")
+	b.WriteString("var registryOUI = map[string]string{\n")
+	for _, prefix := range prefixes {
+		fmt.Fprintf(&b, "\t%q: %q,\n", prefix, vendors[prefix])
+	}
+	b.WriteString("}\n")
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("oui_registry.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Written %v vendors to oui_registry.go", len(vendors))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type geoInfo struct {
+	Country,
+	City string
+}
+
+// GeoIP is the country and the city of the remote addresses from a MaxMind DB file (GeoLite2-City, GeoLite2-Country).
+type GeoIP struct {
+	db        *mmdbFile
+	countries listFilter
+	cache     map[string]geoInfo
+	sync.RWMutex
+}
+
+func newGeoIP(path string, countries []string) *GeoIP {
+	return &GeoIP{db: newMMDBFile(path), countries: newListFilter(countries), cache: map[string]geoInfo{}}
+}
+
+func (g *GeoIP) lookup(ip string) geoInfo {
+	if g.db.path == "" {
+		return geoInfo{}
+	}
+	g.RLock()
+	info, ok := g.cache[ip]
+	g.RUnlock()
+	if ok {
+		return info
+	}
+	record := g.db.lookup(ip)
+	info = geoInfo{
+		Country: mmdbPathString(record, "country", "iso_code"),
+		City:    mmdbPathString(record, "city", "names", "en"),
+	}
+	g.Lock()
+	// The cache is only to not decode the same records for every flow, it is dropped when it grows
+	if len(g.cache) > 100000 {
+		g.cache = map[string]geoInfo{}
+	}
+	g.cache[ip] = info
+	g.Unlock()
+	return info
+}
+
+func (g *GeoIP) loopReload() {
+	for {
+		time.Sleep(time.Minute)
+		if g.db.reload() {
+			g.Lock()
+			g.cache = map[string]geoInfo{}
+			g.Unlock()
+		}
+	}
+}
+
+// allowed checks the country of the remote address against the filter of the countries.
+func (g *GeoIP) allowed(info geoInfo) bool {
+	return g.countries.allowed(info.Country)
+}
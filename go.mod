@@ -3,6 +3,7 @@ module github.com/Rid-lin/gonsquid
 go 1.15
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/cristalhq/aconfig v0.13.4
 	github.com/cristalhq/aconfig/aconfigtoml v0.12.0
 	github.com/go-routeros/routeros v0.0.0-20210123142807-2a44d57c6730
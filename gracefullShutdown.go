@@ -24,7 +24,16 @@ func getExitSignalsChannel() chan os.Signal {
 
 func (transport *Transport) Exit() {
 	<-transport.exitChan
-	transport.clientROS.Close()
+	for _, r := range transport.routers {
+		if r.client != nil {
+			r.client.Close()
+		}
+	}
+	if transport.identities.path != "" {
+		if err := transport.identities.save(); err != nil {
+			log.Printf("Error saving the device identities(%v):%v", transport.identities.path, err)
+		}
+	}
 	transport.fileDestination.Close()
 	transport.conn.Close()
 	log.Println("Shutting down")
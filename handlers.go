@@ -20,12 +20,14 @@ type QuotaType struct {
 type PersonType struct {
 	Name,
 	Position,
-	Company string
+	Company,
+	Department string
 }
 
 type LineOfData struct {
 	// timeoutStr,
 	Comment,
+	Login,
 	disable string
 	addressLists []string
 	// timeoutInt   int64
@@ -35,13 +37,29 @@ type LineOfData struct {
 }
 
 type DeviceType struct {
-	Id       string
-	IP       string
-	TypeD    string
-	Mac      string
-	HostName string
-	Groups   string
-	timeout  time.Time
+	Id         string
+	DeviceID   string
+	ClientID   string
+	IP         string
+	TypeD      string
+	Mac        string
+	HostName   string
+	Groups     string
+	Service    string
+	CallerID   string
+	SSID       string
+	AP         string
+	Signal     string
+	PrevAP     string
+	Roams      int
+	BridgePort string
+	VLAN       string
+	Interface  string
+	Vendor     string
+	// The router owning the device and the source of devices which answered last
+	Router  string
+	Source  string
+	timeout time.Time
 }
 
 func logreq(f func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
@@ -133,6 +151,15 @@ func (data *Transport) handlerGetStatusDevices(w http.ResponseWriter, r *http.Re
 	fmt.Fprint(w, string(json_data))
 }
 
+func (data *Transport) handlerGetRemote(w http.ResponseWriter, r *http.Request) {
+	remote := data.remoteInfo(r.URL.Query().Get("ip"), 0)
+	json_data, err := json.Marshal(remote)
+	if err != nil {
+		log.Errorf("Error witn Marshaling to JSON the remote address:(%v)", err)
+	}
+	fmt.Fprint(w, string(json_data))
+}
+
 func errorResponse(w http.ResponseWriter, message string, httpStatusCode int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(httpStatusCode)
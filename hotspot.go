@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type hotSpotSession struct {
+	Login  string
+	IP     string
+	Mac    string
+	Server string
+	Router string
+	Start  time.Time
+	End    time.Time
+}
+
+type hotSpotUser struct {
+	Name,
+	Profile,
+	Comment string
+}
+
+type HotSpot struct {
+	active  map[string]hotSpotSession
+	users   map[string]hotSpotUser
+	history map[string][]hotSpotSession
+	sync.RWMutex
+}
+
+func (data *Transport) loopGetDataFromHotSpot() {
+	for {
+		data.getHotSpotFromMT()
+
+		interval, err := time.ParseDuration(cfg.HotSpotInterval)
+		if err != nil {
+			interval = time.Minute
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (data *Transport) getHotSpotFromMT() {
+	now := time.Now().In(data.Location)
+
+	active := map[string]hotSpotSession{}
+	users := map[string]hotSpotUser{}
+	failed := data.pollRouters("HotSpot sessions", func(r *router) error {
+		reply, err := r.client.Run("/ip/hotspot/active/print")
+		if err != nil {
+			return err
+		}
+		reply2, err := r.client.Run("/ip/hotspot/user/print")
+		if err != nil {
+			return err
+		}
+		for _, re := range reply.Re {
+			session := hotSpotSession{
+				Login:  re.Map["user"],
+				IP:     re.Map["address"],
+				Mac:    re.Map["mac-address"],
+				Server: re.Map["server"],
+				Router: r.name,
+				Start:  now.Add(-parseROSDuration(re.Map["uptime"])),
+			}
+			if _, ok := active[session.IP]; ok || session.IP == "" || session.Login == "" {
+				continue
+			}
+			active[session.IP] = session
+		}
+		for _, re := range reply2.Re {
+			user := hotSpotUser{
+				Name:    re.Map["name"],
+				Profile: re.Map["profile"],
+				Comment: re.Map["comment"],
+			}
+			users[hotSpotUserKey(r.name, user.Name)] = user
+		}
+		return nil
+	})
+	data.hotSpot.keep(failed, active, users)
+
+	retention, err := time.ParseDuration(cfg.HotSpotHistory)
+	if err != nil {
+		retention = 7 * 24 * time.Hour
+	}
+	data.hotSpot.update(active, users, now, retention)
+	log.Tracef("Get %v active HotSpot sessions from mikrotik", len(active))
+
+	data.Lock()
+	data.hotSpot.mergeInto(data.ipToMac, data.QuotaType)
+	data.Unlock()
+}
+
+// The users of every router are separate
+func hotSpotUserKey(router, name string) string {
+	return router + "/" + name
+}
+
+// keep adds the sessions and the users of the routers which failed, so their sessions are not closed.
+func (h *HotSpot) keep(failed map[string]bool, active map[string]hotSpotSession, users map[string]hotSpotUser) {
+	h.RLock()
+	defer h.RUnlock()
+	for ip, session := range h.active {
+		if _, ok := active[ip]; !ok && failed[session.Router] {
+			active[ip] = session
+		}
+	}
+	for key, user := range h.users {
+		if failed[strings.SplitN(key, "/", 2)[0]] {
+			users[key] = user
+		}
+	}
+}
+
+// update replaces the active sessions. Sessions that are gone or were taken over
+// by another login are closed and moved to the history.
+func (h *HotSpot) update(active map[string]hotSpotSession, users map[string]hotSpotUser, now time.Time, retention time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	if h.history == nil {
+		h.history = map[string][]hotSpotSession{}
+	}
+	for ip, old := range h.active {
+		if current, ok := active[ip]; ok && current.Login == old.Login && current.Mac == old.Mac {
+			current.Start = old.Start
+			active[ip] = current
+			continue
+		}
+		old.End = now
+		h.history[ip] = append(h.history[ip], old)
+	}
+	h.active = active
+	h.users = users
+
+	for ip, sessions := range h.history {
+		kept := sessions[:0]
+		for _, session := range sessions {
+			if now.Sub(session.End) < retention {
+				kept = append(kept, session)
+			}
+		}
+		if len(kept) == 0 {
+			delete(h.history, ip)
+		} else {
+			h.history[ip] = kept
+		}
+	}
+}
+
+// find returns the session that owned the IP at the given time.
+func (h *HotSpot) find(ip string, at time.Time) (hotSpotSession, bool) {
+	h.RLock()
+	defer h.RUnlock()
+	if session, ok := h.active[ip]; ok && !at.Before(session.Start) {
+		return session, true
+	}
+	for _, session := range h.history[ip] {
+		if !at.Before(session.Start) && !at.After(session.End) {
+			return session, true
+		}
+	}
+	return hotSpotSession{}, false
+}
+
+// apply puts the login of the active session and the quotas from the comment
+// of the HotSpot user into the line.
+func (h *HotSpot) apply(line *LineOfData, quota QuotaType) bool {
+	h.RLock()
+	defer h.RUnlock()
+	session, ok := h.active[line.IP]
+	if !ok {
+		return false
+	}
+	if line.Mac == "" || line.Mac == line.IP {
+		line.Mac = session.Mac
+	}
+	line.Login = session.Login
+	if line.Router == "" {
+		line.Router = session.Router
+	}
+	if user, ok := h.users[hotSpotUserKey(session.Router, session.Login)]; ok && user.Comment != "" {
+		line.applyComment(user.Comment, quota)
+	}
+	return true
+}
+
+func (h *HotSpot) mergeInto(ipToMac map[string]LineOfData, quota QuotaType) {
+	h.RLock()
+	ips := make([]string, 0, len(h.active))
+	for ip := range h.active {
+		ips = append(ips, ip)
+	}
+	h.RUnlock()
+
+	mergeIPs(ipToMac, ips, quota, func(line *LineOfData) bool {
+		return h.apply(line, quota)
+	})
+}
+
+func (h *HotSpot) sessions(ip string) []hotSpotSession {
+	h.RLock()
+	defer h.RUnlock()
+	result := []hotSpotSession{}
+	for key, session := range h.active {
+		if ip == "" || ip == key {
+			result = append(result, session)
+		}
+	}
+	for key, sessions := range h.history {
+		if ip == "" || ip == key {
+			result = append(result, sessions...)
+		}
+	}
+	return result
+}
+
+func (data *Transport) handlerGetHotSpotSessions(w http.ResponseWriter, r *http.Request) {
+	ip := r.URL.Query().Get("ip")
+	sessions := data.hotSpot.sessions(ip)
+	if timeStr := r.URL.Query().Get("time"); timeStr != "" {
+		sessions = []hotSpotSession{}
+		if session, ok := data.hotSpot.find(ip, parseRequestTime(timeStr)); ok {
+			sessions = append(sessions, session)
+		}
+	}
+	json_data, err := json.Marshal(sessions)
+	if err != nil {
+		log.Errorf("Error witn Marshaling to JSON HotSpot sessions:(%v)", err)
+	}
+	fmt.Fprint(w, string(json_data))
+}
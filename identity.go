@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The number of the last MAC addresses kept for a device
+const identityMacs = 16
+
+type deviceIdentity struct {
+	ID       string
+	Macs     []string
+	ClientID string `json:",omitempty"`
+	HostName string `json:",omitempty"`
+	Comment  string `json:",omitempty"`
+	LastSeen time.Time
+}
+
+// Identities link the randomized MAC addresses of a device by DHCP client-id, lease comment and host name
+// to a persistent device ID, which is written as the username instead of the MAC address.
+type Identities struct {
+	path       string
+	devices    map[string]*deviceIdentity
+	byMac      map[string]string
+	byClientID map[string]string
+	byComment  map[string]string
+	byHostName map[string]string
+	// The MAC addresses of the last table of the devices, two of them online at once are different devices
+	online  map[string]bool
+	changed bool
+	sync.RWMutex
+}
+
+func newIdentities(path string) *Identities {
+	ids := &Identities{path: path, devices: map[string]*deviceIdentity{}, online: map[string]bool{}}
+	if path != "" {
+		if b, err := ioutil.ReadFile(path); err == nil {
+			list := []*deviceIdentity{}
+			if err := json.Unmarshal(b, &list); err != nil {
+				log.Errorf("Error reading the device identities(%v):%v", path, err)
+			}
+			for _, device := range list {
+				if device.LastSeen.IsZero() {
+					device.LastSeen = time.Now()
+				}
+				ids.devices[device.ID] = device
+			}
+			log.Debugf("Loaded %v device identities from %v", len(list), path)
+		} else if !os.IsNotExist(err) {
+			log.Errorf("Error reading the device identities(%v):%v", path, err)
+		}
+	}
+	ids.reindex()
+	return ids
+}
+
+func (ids *Identities) reindex() {
+	ids.byMac = map[string]string{}
+	ids.byClientID = map[string]string{}
+	ids.byComment = map[string]string{}
+	ids.byHostName = map[string]string{}
+	for id, device := range ids.devices {
+		for _, mac := range device.Macs {
+			ids.byMac[mac] = id
+		}
+		if device.ClientID != "" {
+			ids.byClientID[device.ClientID] = id
+		}
+		if device.Comment != "" {
+			ids.byComment[device.Comment] = id
+		}
+		if device.HostName != "" {
+			ids.byHostName[strings.ToLower(device.HostName)] = id
+		}
+	}
+}
+
+func (data *Transport) loopSaveIdentities() {
+	for {
+		time.Sleep(time.Minute)
+		data.identities.prune(time.Now().Add(-parseDurationOr(cfg.IdentityMaxAge, 90*24*time.Hour)))
+		if err := data.identities.save(); err != nil {
+			log.Errorf("Error saving the device identities(%v):%v", data.identities.path, err)
+		}
+	}
+}
+
+// prune removes the devices not seen since the time.
+func (ids *Identities) prune(since time.Time) {
+	ids.Lock()
+	defer ids.Unlock()
+	pruned := 0
+	for id, device := range ids.devices {
+		if device.LastSeen.Before(since) {
+			delete(ids.devices, id)
+			pruned++
+		}
+	}
+	if pruned > 0 {
+		log.Debugf("Removed %v devices not seen since %v", pruned, since.Format(time.RFC3339))
+		ids.changed = true
+		ids.reindex()
+	}
+}
+
+func (ids *Identities) save() error {
+	ids.Lock()
+	if !ids.changed {
+		ids.Unlock()
+		return nil
+	}
+	list := make([]*deviceIdentity, 0, len(ids.devices))
+	for _, device := range ids.devices {
+		list = append(list, device)
+	}
+	b, err := json.MarshalIndent(list, "", "\t")
+	ids.changed = false
+	ids.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := ids.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ids.path)
+}
+
+// randomizedMac reports whether the MAC address is locally administered, as the private addresses of phones are.
+func randomizedMac(mac string) bool {
+	hw := normalizeMac(mac)
+	return hw != "" && strings.ContainsRune("2367ABEF", rune(hw[1]))
+}
+
+// clientIDOfMac reports whether the DHCP client-id is only the hardware type and the MAC address
+// ("1:aa:bb:c:dd:ee:ff" in RouterOS), which changes with the MAC address.
+func clientIDOfMac(clientID, mac string) bool {
+	parts := strings.Split(clientID, ":")
+	if len(parts) != 7 {
+		return false
+	}
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 16, 8)
+		if err != nil {
+			return false
+		}
+		parts[i] = fmt.Sprintf("%02X", n)
+	}
+	return parts[0] == "01" && strings.Join(parts[1:], ":") == normalizeMac(mac)
+}
+
+// find returns the known device of the line. The MAC address and the client-id are reliable, a comment and a host name
+// link only a randomized MAC and only when the other MAC addresses of the device are not online.
+func (ids *Identities) find(line *LineOfData, mac string) (string, bool) {
+	if id, ok := ids.byMac[mac]; ok {
+		return id, true
+	}
+	if line.ClientID != "" && !clientIDOfMac(line.ClientID, mac) {
+		if id, ok := ids.byClientID[line.ClientID]; ok {
+			return id, true
+		}
+	}
+	if !randomizedMac(mac) {
+		return "", false
+	}
+	if id, ok := ids.byComment[line.Comment]; ok && line.Comment != "" && ids.offline(id) {
+		return id, true
+	}
+	if id, ok := ids.byHostName[strings.ToLower(line.HostName)]; ok && line.HostName != "" && ids.offline(id) {
+		return id, true
+	}
+	return "", false
+}
+
+func (ids *Identities) offline(id string) bool {
+	for _, mac := range ids.devices[id].Macs {
+		if ids.online[mac] {
+			return false
+		}
+	}
+	return true
+}
+
+// apply puts the device ID into the line. A new MAC address of a known device gets its comment with the quotas,
+// so the device does not start from the default quotas.
+func (ids *Identities) apply(line *LineOfData, quota QuotaType) {
+	mac := normalizeMac(line.Mac)
+	if ids.path == "" || mac == "" {
+		return
+	}
+	ids.Lock()
+	defer ids.Unlock()
+	id, ok := ids.find(line, mac)
+	device := ids.devices[id]
+	updated := !ok
+	if !ok {
+		id = "dev-" + strings.ReplaceAll(mac, ":", "")
+		// The MAC address may have been dropped from its old device as too old
+		if device = ids.devices[id]; device == nil {
+			device = &deviceIdentity{ID: id}
+			ids.devices[id] = device
+		}
+	}
+	if _, known := ids.byMac[mac]; !known {
+		updated = true
+		device.Macs = append(device.Macs, mac)
+		if len(device.Macs) > identityMacs {
+			device.Macs = device.Macs[len(device.Macs)-identityMacs:]
+		}
+		if ok {
+			log.Infof("The MAC address %v of %v is linked to the device %v", mac, line.IP, device.ID)
+		}
+	}
+	if line.Comment == "" && device.Comment != "" {
+		line.applyComment(device.Comment, quota)
+	}
+	for _, value := range []struct{ from, to *string }{
+		{&line.ClientID, &device.ClientID},
+		{&line.Comment, &device.Comment},
+		{&line.HostName, &device.HostName},
+	} {
+		if *value.from != "" && *value.from != *value.to {
+			*value.to = *value.from
+			updated = true
+		}
+	}
+	if updated {
+		ids.changed = true
+		ids.reindex()
+	}
+	// LastSeen is saved once an hour, the devices are pruned by it after a restart too
+	if time.Since(device.LastSeen) > time.Hour {
+		ids.changed = true
+	}
+	device.LastSeen = time.Now()
+	line.DeviceID = device.ID
+}
+
+func (ids *Identities) mergeInto(ipToMac map[string]LineOfData, quota QuotaType) {
+	if ids.path == "" {
+		return
+	}
+	online := map[string]bool{}
+	for _, line := range ipToMac {
+		if mac := normalizeMac(line.Mac); mac != "" {
+			online[mac] = true
+		}
+	}
+	ids.Lock()
+	ids.online = online
+	ids.Unlock()
+	// The first line wins a comment or a host name of an offline device, so the lines are applied in the same order
+	ips := make([]string, 0, len(ipToMac))
+	for ip := range ipToMac {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	for _, ip := range ips {
+		line := ipToMac[ip]
+		ids.apply(&line, quota)
+		ipToMac[ip] = line
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func identityLine(ip, mac, comment, hostName string) LineOfData {
+	line := LineOfData{}
+	line.IP, line.Mac, line.Comment, line.HostName = ip, mac, comment, hostName
+	return line
+}
+
+func TestIdentitiesLinkRandomizedOnly(t *testing.T) {
+	ids := newIdentities(filepath.Join(t.TempDir(), "devices.json"))
+	ids.mergeInto(map[string]LineOfData{"10.0.0.2": identityLine("10.0.0.2", "AA:00:00:00:00:01", "", "phone")}, QuotaType{})
+
+	table := map[string]LineOfData{
+		// A new random MAC address of the phone, the old one is offline
+		"10.0.0.3": identityLine("10.0.0.3", "DA:00:00:00:00:02", "", "phone"),
+		// The comment and the host name of a MAC address of a vendor are not linked
+		"10.0.0.4": identityLine("10.0.0.4", "00:80:77:00:00:03", "", "phone"),
+	}
+	ids.mergeInto(table, QuotaType{})
+	if id := table["10.0.0.3"].DeviceID; id != "dev-AA0000000001" {
+		t.Errorf("random MAC linked to %v", id)
+	}
+	if id := table["10.0.0.4"].DeviceID; id != "dev-008077000003" {
+		t.Errorf("vendor MAC linked to %v", id)
+	}
+}
+
+func TestIdentitiesMergeIntoOrder(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		ids := newIdentities(filepath.Join(t.TempDir(), "devices.json"))
+		ids.mergeInto(map[string]LineOfData{"10.0.0.9": identityLine("10.0.0.9", "AA:00:00:00:00:01", "Ivanov", "")}, QuotaType{})
+		// Two new random MAC addresses with the comment of the offline device, only the first line is linked
+		table := map[string]LineOfData{
+			"10.0.0.2": identityLine("10.0.0.2", "AA:00:00:00:00:02", "Ivanov", ""),
+			"10.0.0.3": identityLine("10.0.0.3", "AA:00:00:00:00:03", "Ivanov", ""),
+			"10.0.0.4": identityLine("10.0.0.4", "AA:00:00:00:00:04", "Ivanov", ""),
+		}
+		ids.mergeInto(table, QuotaType{})
+		if table["10.0.0.2"].DeviceID != "dev-AA0000000001" || table["10.0.0.3"].DeviceID != "dev-AA0000000003" {
+			t.Fatalf("devices %v, %v", table["10.0.0.2"].DeviceID, table["10.0.0.3"].DeviceID)
+		}
+	}
+}
+
+func TestIdentitiesPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+	ids := newIdentities(path)
+	ids.mergeInto(map[string]LineOfData{
+		"10.0.0.2": identityLine("10.0.0.2", "AA:00:00:00:00:01", "", "old"),
+		"10.0.0.3": identityLine("10.0.0.3", "AA:00:00:00:00:02", "", "new"),
+	}, QuotaType{})
+	ids.devices["dev-AA0000000001"].LastSeen = time.Now().Add(-100 * 24 * time.Hour)
+	ids.prune(time.Now().Add(-90 * 24 * time.Hour))
+	if err := ids.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newIdentities(path)
+	if _, ok := loaded.devices["dev-AA0000000001"]; ok {
+		t.Error("the old device is kept")
+	}
+	if _, ok := loaded.byMac["AA:00:00:00:00:01"]; ok {
+		t.Error("the MAC address of the old device is kept")
+	}
+	if _, ok := loaded.devices["dev-AA0000000002"]; !ok {
+		t.Error("the new device is removed")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Interfaces are the names of the interfaces of the Mikrotik routers by ifIndex (InputSnmp, OutputSnmp of the flows).
+type Interfaces struct {
+	// router -> ifIndex -> name
+	names map[string]map[int]string
+	sync.RWMutex
+}
+
+// The OID of the name of the interface printed with oid, the last number is the SNMP ifIndex.
+var oidIfDescr = parseOID("1.3.6.1.2.1.2.2.1.2")
+
+// getInterfacesFromMT reads /interface. The flows carry the SNMP ifIndex of the interfaces (InputSnmp, OutputSnmp),
+// it is taken from the OIDs of "/interface print oid". The number of .id is used only for the interfaces without
+// an OID, e.g. over the REST API, RouterOS does not document it as the ifIndex.
+func (data *Transport) getInterfacesFromMT(r *router) {
+	reply, err := r.client.Run("/interface/print", "=.proplist=.id,name")
+	if err != nil {
+		log.Errorf("Error getting interfaces from %v:%v", r.name, err)
+		return
+	}
+	ifIndexes := map[string]int{}
+	if oids, err := r.client.Run("/interface/print", "=oid=", "=.proplist=.id,name"); err != nil {
+		log.Debugf("Error getting the OIDs of interfaces from %v:%v", r.name, err)
+	} else {
+		for _, re := range oids.Re {
+			if oid := parseOID(re.Map["name"]); len(oid) == len(oidIfDescr)+1 && oidHasPrefix(oid, oidIfDescr) {
+				ifIndexes[re.Map[".id"]] = oid[len(oidIfDescr)]
+			}
+		}
+	}
+	names := map[int]string{}
+	for _, re := range reply.Re {
+		if ifIndex, ok := ifIndexes[re.Map[".id"]]; ok {
+			names[ifIndex] = re.Map["name"]
+			continue
+		}
+		index, err := strconv.ParseUint(strings.TrimPrefix(re.Map[".id"], "*"), 16, 32)
+		if err != nil {
+			continue
+		}
+		names[int(index)] = re.Map["name"]
+	}
+	data.interfaces.Lock()
+	if data.interfaces.names == nil {
+		data.interfaces.names = map[string]map[int]string{}
+	}
+	data.interfaces.names[r.name] = names
+	data.interfaces.Unlock()
+	log.Tracef("Get %v interfaces (%v by OID) from %v", len(names), len(ifIndexes), r.name)
+}
+
+// interfaceName returns the name of the interface of the exporter, the SNMP ifName if the exporter is polled over SNMP,
+// otherwise the name from the Mikrotik router with the address of the exporter or from the first router, as the flows
+// may come from another address of it. Unknown interfaces are written as the index.
+func (data *Transport) interfaceName(exporter string, index uint16) string {
+	if index == 0 {
+		return ""
+	}
+	if name, ok := data.snmp.interfaceName(exporter, int(index)); ok {
+		return name
+	}
+	data.interfaces.RLock()
+	names, ok := data.interfaces.names[exporter]
+	if !ok && len(data.routers) > 0 {
+		names = data.interfaces.names[data.routers[0].name]
+	}
+	name, ok := names[int(index)]
+	data.interfaces.RUnlock()
+	if ok {
+		return name
+	}
+	return strconv.Itoa(int(index))
+}
+
+func (s *SNMPSource) interfaceName(exporter string, index int) (string, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, e := range s.exporters {
+		host, _, err := net.SplitHostPort(e.name)
+		if err != nil {
+			host = e.name
+		}
+		if host == exporter {
+			name, ok := e.ifNames[index]
+			return name, ok
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-routeros/routeros"
+	"github.com/go-routeros/routeros/proto"
+)
+
+// fakeRouterClient answers the commands by the function.
+type fakeRouterClient struct {
+	run func(sentence ...string) (*routeros.Reply, error)
+}
+
+func (c *fakeRouterClient) Run(sentence ...string) (*routeros.Reply, error) {
+	return c.run(sentence...)
+}
+
+func (c *fakeRouterClient) RunContext(ctx context.Context, sentence ...string) (*routeros.Reply, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.run(sentence...)
+}
+
+func (c *fakeRouterClient) Close() {}
+
+func testReply(items ...map[string]string) *routeros.Reply {
+	reply := &routeros.Reply{}
+	for _, item := range items {
+		reply.Re = append(reply.Re, &proto.Sentence{Map: item})
+	}
+	return reply
+}
+
+func TestGetInterfacesFromMT(t *testing.T) {
+	for name, withOID := range map[string]bool{"oid": true, "no oid": false} {
+		r := &router{name: "r1", client: &fakeRouterClient{run: func(sentence ...string) (*routeros.Reply, error) {
+			if strings.Join(sentence, " ") == "/interface/print =.proplist=.id,name" {
+				return testReply(map[string]string{".id": "*1", "name": "ether1"}, map[string]string{".id": "*A", "name": "vlan10"}), nil
+			}
+			if !withOID {
+				return nil, errors.New("no such command")
+			}
+			return testReply(map[string]string{".id": "*1", "name": ".1.3.6.1.2.1.2.2.1.2.1"},
+				map[string]string{".id": "*A", "name": ".1.3.6.1.2.1.2.2.1.2.12"}), nil
+		}}}
+		data := &Transport{routers: []*router{r}, snmp: &SNMPSource{}}
+		data.getInterfacesFromMT(r)
+		vlanIndex := uint16(10)
+		if withOID {
+			vlanIndex = 12
+		}
+		if got := data.interfaceName("r1", 1); got != "ether1" {
+			t.Errorf("%v: ifIndex 1 is %v", name, got)
+		}
+		if got := data.interfaceName("r1", vlanIndex); got != "vlan10" {
+			t.Errorf("%v: ifIndex %v is %v", name, vlanIndex, got)
+		}
+	}
+}
+
+func TestFiltredMessageInterfaces(t *testing.T) {
+	record := &decodedRecord{}
+	record.InputInterface, record.OutputInterface = "ether1", "vlan10"
+	cases := []struct {
+		ignor  string
+		passed bool
+	}{
+		{"in:ether1", false},
+		{"in:vlan10", true},
+		{"out:VLAN10", false},
+		{"iface:vlan10", false},
+		{"iface:ether2", true},
+		{"10.0.0.5", false},
+		{"8.8.8.8:53", true},
+	}
+	for _, c := range cases {
+		if got := filtredMessage("1 10.0.0.5 TCP_PACKET/200", record, []string{c.ignor}) != ""; got != c.passed {
+			t.Errorf("%v: passed %v", c.ignor, got)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func isIPv6(ip string) bool {
+	addr := net.ParseIP(ip)
+	return addr != nil && addr.To4() == nil
+}
+
+// macFromDUID extracts the link-layer address from DUID-LLT and DUID-LL, the other types do not contain it.
+func macFromDUID(duid string) string {
+	duid = strings.TrimPrefix(strings.ReplaceAll(duid, ":", ""), "0x")
+	b, err := hex.DecodeString(duid)
+	if err != nil || len(b) < 4 {
+		return ""
+	}
+	// only Ethernet (hardware type 1)
+	if b[2] != 0 || b[3] != 1 {
+		return ""
+	}
+	var mac []byte
+	switch {
+	case b[1] == 1 && b[0] == 0 && len(b) == 14:
+		mac = b[8:]
+	case b[1] == 3 && b[0] == 0 && len(b) == 10:
+		mac = b[4:]
+	default:
+		return ""
+	}
+	return strings.ToUpper(net.HardwareAddr(mac).String())
+}
+
+type dhcpv6Binding struct {
+	Mac,
+	Comment string
+	network *net.IPNet
+}
+
+func getDHCPv6BindingsFromMT(ctx context.Context, r *router) ([]dhcpv6Binding, error) {
+	reply, err := r.client.RunContext(ctx, "/ipv6/dhcp-server/binding/print")
+	if err != nil {
+		return nil, err
+	}
+	bindings := []dhcpv6Binding{}
+	for _, re := range reply.Re {
+		binding := dhcpv6Binding{
+			Mac:     strings.ToUpper(re.Map["mac-address"]),
+			Comment: re.Map["comment"],
+		}
+		if binding.Mac == "" {
+			binding.Mac = macFromDUID(re.Map["duid"])
+		}
+		network, err := parseAllowedAddress(re.Map["address"])
+		if err != nil || binding.Mac == "" {
+			continue
+		}
+		binding.network = network
+		bindings = append(bindings, binding)
+	}
+	return bindings, nil
+}
+
+// getInfoFromMTAboutIPv6 finds the MAC address of an IPv6 address in the neighbor table
+// and, for the addresses assigned by DHCPv6, in the bindings of the routers.
+func (data *Transport) getInfoFromMTAboutIPv6(ctx context.Context, device *DeviceType) {
+	addr := net.ParseIP(device.IP)
+	for _, r := range data.routersFor(device.IP) {
+		reply, err := r.client.RunContext(ctx, "/ipv6/neighbor/print", "?address="+device.IP)
+		if err == nil {
+			for _, re := range reply.Re {
+				device.Mac = strings.ToUpper(re.Map["mac-address"])
+			}
+			if device.Mac != "" {
+				device.Router = r.name
+				return
+			}
+		}
+		bindings, err2 := getDHCPv6BindingsFromMT(ctx, r)
+		if ctx.Err() != nil {
+			return
+		}
+		for _, err := range []error{err, err2} {
+			if err != nil && err != errRouterDown {
+				log.Errorf("Error getting the IPv6 neighbor of %v from %v:%v", device.IP, r.name, err)
+			}
+		}
+		for _, binding := range bindings {
+			if binding.network.Contains(addr) {
+				device.Mac = binding.Mac
+				device.Router = r.name
+				return
+			}
+		}
+	}
+}
+
+// ipv4ByMac indexes the lines with IPv4 addresses by MAC address.
+func ipv4ByMac(ipToMac map[string]LineOfData) map[string]LineOfData {
+	byMac := map[string]LineOfData{}
+	for ip, line := range ipToMac {
+		if isIPv6(ip) || line.Mac == "" || line.Mac == ip {
+			continue
+		}
+		byMac[strings.ToUpper(line.Mac)] = line
+	}
+	return byMac
+}
+
+// linkToIPv4 gives the line of an IPv6 address the identity of the IPv4 lease of the same device:
+// the MAC address, the comment, the quotas and the person. The login and the source of the line are kept.
+func linkToIPv4(line *LineOfData, byMac map[string]LineOfData) bool {
+	ipv4Line, ok := byMac[strings.ToUpper(line.Mac)]
+	if !ok {
+		return false
+	}
+	line.Mac = ipv4Line.Mac
+	line.Comment = ipv4Line.Comment
+	line.TypeD = ipv4Line.TypeD
+	line.QuotaType = ipv4Line.QuotaType
+	line.PersonType = ipv4Line.PersonType
+	return true
+}
+
+// getIPv6FromMT adds the addresses of the IPv6 neighbor tables and DHCPv6 bindings of the routers.
+func (data *Transport) getIPv6FromMT(ipToMac map[string]LineOfData, quota QuotaType) {
+	byMac := ipv4ByMac(ipToMac)
+	add := func(r *router, ip, mac, comment string) {
+		if existing, ok := ipToMac[ip]; ok && existing.Router != "" && existing.Router != r.name {
+			// The address is behind the router listed first
+			return
+		}
+		line := LineOfData{}
+		line.IP = ip
+		line.Mac = mac
+		if !linkToIPv4(&line, byMac) {
+			line.setDefaultQuotas(quota)
+			if comment != "" {
+				line.applyComment(comment, quota)
+			}
+		}
+		line.Router = r.name
+		line.timeout = time.Now()
+		ipToMac[ip] = line
+	}
+
+	data.pollRouters("IPv6 neighbors", func(r *router) error {
+		reply, err := r.client.Run("/ipv6/neighbor/print")
+		if err != nil {
+			return err
+		}
+		bindings, err := getDHCPv6BindingsFromMT(context.Background(), r)
+		if err != nil {
+			log.Errorf("Error getting DHCPv6 bindings from %v:%v", r.name, err)
+		}
+		for _, re := range reply.Re {
+			ip := re.Map["address"]
+			mac := strings.ToUpper(re.Map["mac-address"])
+			if addr := net.ParseIP(ip); addr == nil || addr.IsLinkLocalUnicast() || mac == "" {
+				continue
+			}
+			add(r, ip, mac, "")
+		}
+		for _, binding := range bindings {
+			if ones, bits := binding.network.Mask.Size(); ones != bits {
+				// Delegated prefixes are resolved on request
+				continue
+			}
+			add(r, binding.network.IP.String(), binding.Mac, binding.Comment)
+		}
+		return nil
+	})
+}
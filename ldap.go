@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LDAP v3 client (RFC 4511), only simple bind and search.
+
+const (
+	ldapBindRequest     = 0x60
+	ldapBindResponse    = 0x61
+	ldapUnbindRequest   = 0x42
+	ldapSearchRequest   = 0x63
+	ldapSearchEntry     = 0x64
+	ldapSearchDone      = 0x65
+	ldapSearchReference = 0x73
+
+	ldapScopeBase    = 0
+	ldapScopeSubtree = 2
+
+	ldapFilterAnd       = 0xa0
+	ldapFilterOr        = 0xa1
+	ldapFilterNot       = 0xa2
+	ldapFilterEquality  = 0xa3
+	ldapFilterSubstring = 0xa4
+	ldapFilterGreater   = 0xa5
+	ldapFilterLess      = 0xa6
+	ldapFilterPresent   = 0x87
+	ldapFilterApprox    = 0xa8
+)
+
+type ldapEntry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+func (e ldapEntry) get(name string) string {
+	for attr, values := range e.Attributes {
+		if strings.EqualFold(attr, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+type ldapConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageID int64
+	timeout   time.Duration
+}
+
+// dialLDAP connects to ldap://host[:port] or ldaps://host[:port].
+func dialLDAP(rawURL string, timeout time.Duration) (*ldapConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		conn, err = dialer.Dial("tcp", hostWithPort(u.Host, "389"))
+	case "ldaps":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostWithPort(u.Host, "636"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("unknown scheme of LDAP URL(%v)", rawURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ldapConn{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+func hostWithPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func (l *ldapConn) Close() error {
+	l.send(berEncode(ldapUnbindRequest, nil))
+	return l.conn.Close()
+}
+
+func (l *ldapConn) send(op []byte) error {
+	l.messageID++
+	if err := l.conn.SetDeadline(time.Now().Add(l.timeout)); err != nil {
+		return err
+	}
+	_, err := l.conn.Write(berConstructed(berSequence, berInt(berInteger, l.messageID), op))
+	return err
+}
+
+// receive reads the next message and returns its protocol operation.
+func (l *ldapConn) receive() (berElement, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(l.reader, header); err != nil {
+		return berElement{}, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		lengthBytes := make([]byte, length&0x7f)
+		if len(lengthBytes) == 0 || len(lengthBytes) > 3 {
+			return berElement{}, errors.New("ldap: wrong length of message")
+		}
+		if _, err := io.ReadFull(l.reader, lengthBytes); err != nil {
+			return berElement{}, err
+		}
+		length = 0
+		for _, c := range lengthBytes {
+			length = length<<8 | int(c)
+		}
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(l.reader, content); err != nil {
+		return berElement{}, err
+	}
+	elements, err := berElements(content)
+	if err != nil {
+		return berElement{}, err
+	}
+	if len(elements) < 2 {
+		return berElement{}, errors.New("ldap: short message")
+	}
+	return elements[1], nil
+}
+
+// The result codes, which are not the errors of the connection
+const (
+	ldapSuccess           = 0
+	ldapSizeLimitExceeded = 4
+)
+
+// ldapResultError is the result code of an operation from the server, the connection is still usable.
+type ldapResultError struct {
+	code    int64
+	message string
+}
+
+func (err *ldapResultError) Error() string {
+	return fmt.Sprintf("ldap: result code %v: %v", err.code, err.message)
+}
+
+func ldapResult(op berElement) error {
+	elements, err := berElements(op.content)
+	if err != nil {
+		return err
+	}
+	if len(elements) < 3 {
+		return errors.New("ldap: wrong result")
+	}
+	if code := berParseInt(elements[0].content); code != ldapSuccess {
+		return &ldapResultError{code: code, message: string(elements[2].content)}
+	}
+	return nil
+}
+
+func (l *ldapConn) bind(dn, password string) error {
+	err := l.send(berConstructed(ldapBindRequest,
+		berInt(berInteger, 3),
+		berString(berOctetString, dn),
+		berString(0x80, password),
+	))
+	if err != nil {
+		return err
+	}
+	op, err := l.receive()
+	if err != nil {
+		return err
+	}
+	if op.tag != ldapBindResponse {
+		return fmt.Errorf("ldap: unexpected response 0x%x to bind", op.tag)
+	}
+	return ldapResult(op)
+}
+
+// search returns the entries found, no more than sizeLimit (0 - the limit of the server).
+func (l *ldapConn) search(baseDN string, scope int, filter string, attributes []string, sizeLimit int) ([]ldapEntry, error) {
+	encodedFilter, err := ldapFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []byte{}
+	for _, attr := range attributes {
+		attrs = append(attrs, berString(berOctetString, attr)...)
+	}
+	err = l.send(berConstructed(ldapSearchRequest,
+		berString(berOctetString, baseDN),
+		berInt(berEnumerated, int64(scope)),
+		berInt(berEnumerated, 0), // neverDerefAliases
+		berInt(berInteger, int64(sizeLimit)),
+		berInt(berInteger, int64(l.timeout/time.Second)),
+		berBool(0x01, false),
+		encodedFilter,
+		berEncode(berSequence, attrs),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []ldapEntry{}
+	for {
+		op, err := l.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case ldapSearchEntry:
+			entry, err := parseLDAPEntry(op.content)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case ldapSearchReference:
+		case ldapSearchDone:
+			err := ldapResult(op)
+			if resultErr, ok := err.(*ldapResultError); ok && resultErr.code == ldapSizeLimitExceeded {
+				// The entries up to the limit are returned
+				err = nil
+			}
+			return entries, err
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response 0x%x to search", op.tag)
+		}
+	}
+}
+
+func parseLDAPEntry(b []byte) (ldapEntry, error) {
+	entry := ldapEntry{Attributes: map[string][]string{}}
+	elements, err := berElements(b)
+	if err != nil || len(elements) != 2 {
+		return entry, errors.New("ldap: wrong search entry")
+	}
+	entry.DN = string(elements[0].content)
+	attrs, err := berElements(elements[1].content)
+	if err != nil {
+		return entry, err
+	}
+	for _, attr := range attrs {
+		pair, err := berElements(attr.content)
+		if err != nil || len(pair) != 2 {
+			return entry, errors.New("ldap: wrong attribute")
+		}
+		values, err := berElements(pair[1].content)
+		if err != nil {
+			return entry, err
+		}
+		for _, value := range values {
+			entry.Attributes[string(pair[0].content)] = append(entry.Attributes[string(pair[0].content)], string(value.content))
+		}
+	}
+	return entry, nil
+}
+
+// ldapEscape escapes the value to be put into a filter (RFC 4515).
+func ldapEscape(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// ldapFilter encodes the string representation of a filter (RFC 4515):
+// &, |, !, =, ~=, >=, <=, presence and substrings.
+func ldapFilter(filter string) ([]byte, error) {
+	filter = strings.TrimSpace(filter)
+	if !strings.HasPrefix(filter, "(") {
+		filter = "(" + filter + ")"
+	}
+	encoded, rest, err := parseLDAPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: unexpected %q at the end of the filter", rest)
+	}
+	return encoded, nil
+}
+
+func parseLDAPFilter(s string) ([]byte, string, error) {
+	if len(s) < 3 || s[0] != '(' {
+		return nil, "", fmt.Errorf("ldap: wrong filter %q", s)
+	}
+	switch s[1] {
+	case '&', '|':
+		tag := byte(ldapFilterAnd)
+		if s[1] == '|' {
+			tag = ldapFilterOr
+		}
+		var content []byte
+		rest := s[2:]
+		for strings.HasPrefix(rest, "(") {
+			part, r, err := parseLDAPFilter(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			content = append(content, part...)
+			rest = r
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("ldap: unclosed filter %q", s)
+		}
+		return berEncode(tag, content), rest[1:], nil
+	case '!':
+		part, rest, err := parseLDAPFilter(s[2:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("ldap: unclosed filter %q", s)
+		}
+		return berEncode(ldapFilterNot, part), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("ldap: unclosed filter %q", s)
+	}
+	item, rest := s[1:end], s[end+1:]
+	eq := strings.IndexByte(item, '=')
+	if eq < 1 {
+		return nil, "", fmt.Errorf("ldap: wrong filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+	tag := byte(ldapFilterEquality)
+	switch attr[len(attr)-1] {
+	case '~':
+		tag = ldapFilterApprox
+	case '>':
+		tag = ldapFilterGreater
+	case '<':
+		tag = ldapFilterLess
+	}
+	if tag != ldapFilterEquality {
+		attr = attr[:len(attr)-1]
+	}
+	switch {
+	case tag == ldapFilterEquality && value == "*":
+		return berString(ldapFilterPresent, attr), rest, nil
+	case tag == ldapFilterEquality && strings.Contains(value, "*"):
+		parts := strings.Split(value, "*")
+		var substrings []byte
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			unescaped, err := ldapUnescape(part)
+			if err != nil {
+				return nil, "", err
+			}
+			kind := byte(0x81) // any
+			if i == 0 {
+				kind = 0x80 // initial
+			} else if i == len(parts)-1 {
+				kind = 0x82 // final
+			}
+			substrings = append(substrings, berString(kind, unescaped)...)
+		}
+		return berConstructed(ldapFilterSubstring, berString(berOctetString, attr), berEncode(berSequence, substrings)), rest, nil
+	}
+	unescaped, err := ldapUnescape(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return berConstructed(tag, berString(berOctetString, attr), berString(berOctetString, unescaped)), rest, nil
+}
+
+func ldapUnescape(value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+3 > len(value) {
+			return "", fmt.Errorf("ldap: wrong escape in %q", value)
+		}
+		var c byte
+		if _, err := fmt.Sscanf(value[i+1:i+3], "%02x", &c); err != nil {
+			return "", fmt.Errorf("ldap: wrong escape in %q", value)
+		}
+		b.WriteByte(c)
+		i += 2
+	}
+	return b.String(), nil
+}
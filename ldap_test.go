@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testLDAPEntry struct {
+	dn    string
+	attrs map[string]string
+}
+
+// ldapServer is a local LDAP server with simple bind and search by &, |, equality and presence filters.
+func ldapServer(t *testing.T, bindDN, password string, entries []testLDAPEntry) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveLDAP(conn, bindDN, password, entries)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func ldapResultOp(tag byte, code int64, message string) []byte {
+	return berConstructed(tag, berInt(berEnumerated, code), berString(berOctetString, ""), berString(berOctetString, message))
+}
+
+func serveLDAP(conn net.Conn, bindDN, password string, entries []testLDAPEntry) {
+	defer conn.Close()
+	server := &ldapConn{conn: conn, reader: bufio.NewReader(conn), timeout: 5 * time.Second}
+	for {
+		op, err := server.receive()
+		if err != nil {
+			return
+		}
+		elements, _ := berElements(op.content)
+		switch op.tag {
+		case ldapBindRequest:
+			code := int64(49) // invalidCredentials
+			if string(elements[1].content) == bindDN && string(elements[2].content) == password {
+				code = 0
+			}
+			server.send(ldapResultOp(ldapBindResponse, code, ""))
+		case ldapSearchRequest:
+			baseDN := strings.ToLower(string(elements[0].content))
+			scope := berParseInt(elements[1].content)
+			sizeLimit := int(berParseInt(elements[3].content))
+			found := []testLDAPEntry{}
+			for _, entry := range entries {
+				dn := strings.ToLower(entry.dn)
+				inScope := dn == baseDN || scope == ldapScopeSubtree && strings.HasSuffix(dn, ","+baseDN)
+				if inScope && matchLDAPFilter(berElement{elements[6].tag, elements[6].content}, entry) {
+					found = append(found, entry)
+				}
+			}
+			if scope == ldapScopeBase && len(found) == 0 {
+				server.send(ldapResultOp(ldapSearchDone, 32, "noSuchObject"))
+				continue
+			}
+			attributes, _ := berElements(elements[7].content)
+			code := int64(0)
+			for i, entry := range found {
+				if sizeLimit > 0 && i == sizeLimit {
+					code = ldapSizeLimitExceeded
+					break
+				}
+				attrs := []byte{}
+				for _, attr := range attributes {
+					if value, ok := entry.attrs[string(attr.content)]; ok {
+						attrs = append(attrs, berConstructed(berSequence, berString(berOctetString, string(attr.content)),
+							berConstructed(berSet, berString(berOctetString, value)))...)
+					}
+				}
+				server.send(berConstructed(ldapSearchEntry, berString(berOctetString, entry.dn), berEncode(berSequence, attrs)))
+			}
+			server.send(ldapResultOp(ldapSearchDone, code, ""))
+		case ldapUnbindRequest:
+			return
+		}
+	}
+}
+
+func matchLDAPFilter(filter berElement, entry testLDAPEntry) bool {
+	switch filter.tag {
+	case ldapFilterAnd, ldapFilterOr:
+		parts, _ := berElements(filter.content)
+		for _, part := range parts {
+			if matchLDAPFilter(part, entry) != (filter.tag == ldapFilterAnd) {
+				return filter.tag != ldapFilterAnd
+			}
+		}
+		return filter.tag == ldapFilterAnd
+	case ldapFilterEquality:
+		pair, _ := berElements(filter.content)
+		return strings.EqualFold(entry.attrs[string(pair[0].content)], string(pair[1].content))
+	case ldapFilterPresent:
+		_, ok := entry.attrs[string(filter.content)]
+		return ok || strings.EqualFold(string(filter.content), "objectClass")
+	}
+	return false
+}
+
+func TestDirectoryLookup(t *testing.T) {
+	owner := "cn=Ivanov,ou=users,dc=example,dc=com"
+	address := ldapServer(t, "cn=reader,dc=example,dc=com", "secret", []testLDAPEntry{
+		{"cn=pc1,ou=computers,dc=example,dc=com", map[string]string{"objectClass": "computer", "cn": "pc1", "managedBy": owner}},
+		{"cn=dup,ou=computers,dc=example,dc=com", map[string]string{"objectClass": "computer", "cn": "dup", "managedBy": owner}},
+		{"cn=dup,ou=old,dc=example,dc=com", map[string]string{"objectClass": "computer", "cn": "dup", "managedBy": owner}},
+		{"cn=orphan,ou=computers,dc=example,dc=com", map[string]string{"objectClass": "computer", "cn": "orphan", "managedBy": "cn=Gone,ou=users,dc=example,dc=com"}},
+		{owner, map[string]string{"objectClass": "user", "displayName": "Ivan Ivanov", "title": "Engineer", "department": "IT"}},
+	})
+	saved := cfg
+	defer func() { cfg = saved }()
+	cfg.LDAPURL = "ldap://" + address
+	cfg.LDAPBindDN = "cn=reader,dc=example,dc=com"
+	cfg.LDAPBindPassword = "secret"
+	cfg.LDAPBaseDN = "dc=example,dc=com"
+	cfg.LDAPFilter = "(&(objectClass=computer)(cn={value}))"
+	cfg.LDAPOwnerAttribute = "managedBy"
+	cfg.LDAPTimeout = "2s"
+	cfg.LDAPCacheTTL = "1h"
+	cfg.LDAPNegativeTTL = "10m"
+
+	d := newDirectory("hostname", []string{"name=displayName", "position=title", "department=department"})
+	keys := map[string]bool{"pc1": true, "dup": true, "orphan": true, "pc404": true}
+	if err := d.lookupAll(keys); err != nil {
+		t.Fatal(err)
+	}
+	for key, found := range map[string]bool{"pc1": true, "dup": true, "orphan": false, "pc404": false} {
+		record, ok := d.cache[key]
+		if !ok || record.found != found {
+			t.Errorf("%v: cached %v, record %+v", key, ok, record)
+			continue
+		}
+		ttl := time.Until(record.expires)
+		if found && ttl < 50*time.Minute || !found && (ttl > 10*time.Minute || ttl < 9*time.Minute) {
+			t.Errorf("%v: expires in %v", key, ttl)
+		}
+	}
+
+	line := LineOfData{}
+	line.HostName = "pc1"
+	if !d.apply(&line) || line.Name != "Ivan Ivanov" || line.Position != "Engineer" || line.Department != "IT" {
+		t.Errorf("owner %+v", line.PersonType)
+	}
+
+	cfg.LDAPBindPassword = "wrong"
+	if err := d.lookupAll(map[string]bool{"pc2": true}); err == nil {
+		t.Error("no error with a wrong password")
+	}
+	if _, ok := d.cache["pc2"]; ok {
+		t.Error("a key is cached without a lookup")
+	}
+}
+
+func TestLDAPFilter(t *testing.T) {
+	for _, filter := range []string{"(cn=pc1)", "(&(objectClass=computer)(|(cn=pc1)(cn=pc2)))", "(!(cn=a\\2ab))", "(cn=pc*)", "(objectClass=*)"} {
+		if _, err := ldapFilter(filter); err != nil {
+			t.Errorf("%v: %v", filter, err)
+		}
+	}
+	for _, filter := range []string{"", "(cn=pc1", "cn=pc1)", "(cn=\\zz)"} {
+		if _, err := ldapFilter(filter); err == nil {
+			t.Errorf("%v: no error", filter)
+		}
+	}
+	if got := ldapEscape("a*(b)\\"); got != "a\\2a\\28b\\29\\5c" {
+		t.Errorf("ldapEscape %v", got)
+	}
+}
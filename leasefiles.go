@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type fileLease struct {
+	IP,
+	Mac,
+	HostName,
+	ClientID,
+	Comment string
+}
+
+type leaseFile struct {
+	kind,
+	path string
+	modTime time.Time
+	size    int64
+	leases  []fileLease
+}
+
+// LeaseFiles are the lease files of DHCP servers running on Linux gateways instead of a Mikrotik router.
+type LeaseFiles struct {
+	files []*leaseFile
+	sync.RWMutex
+}
+
+var leaseFileParsers = map[string]func(r io.Reader, now time.Time) []fileLease{
+	"dnsmasq": parseDnsmasqLeases,
+	"isc":     parseISCLeases,
+	"kea":     parseKeaLeases,
+}
+
+func newLeaseFiles(list []string) *LeaseFiles {
+	leaseFiles := &LeaseFiles{}
+	for _, value := range list {
+		if value == "" {
+			continue
+		}
+		arr := strings.SplitN(value, ":", 2)
+		if len(arr) != 2 || leaseFileParsers[arr[0]] == nil {
+			log.Errorf("Error parse lease file(%v), the format is type:path, type is dnsmasq, isc or kea", value)
+			continue
+		}
+		leaseFiles.files = append(leaseFiles.files, &leaseFile{kind: arr[0], path: arr[1]})
+	}
+	return leaseFiles
+}
+
+func (data *Transport) loopReadLeaseFiles() {
+	for {
+		if data.leaseFiles.read(time.Now()) {
+			data.Lock()
+			data.leaseFiles.mergeInto(data.ipToMac, data.QuotaType)
+			data.Unlock()
+		}
+
+		interval, err := time.ParseDuration(cfg.LeaseFilesInterval)
+		if err != nil {
+			interval = 10 * time.Second
+		}
+		time.Sleep(interval)
+	}
+}
+
+// read re-reads the files which have changed since the last reading.
+func (lf *LeaseFiles) read(now time.Time) bool {
+	lf.Lock()
+	defer lf.Unlock()
+	changed := false
+	for _, file := range lf.files {
+		info, err := os.Stat(file.path)
+		if err != nil {
+			log.Errorf("Error reading lease file(%v):%v", file.path, err)
+			continue
+		}
+		if info.ModTime().Equal(file.modTime) && info.Size() == file.size {
+			continue
+		}
+		f, err := os.Open(file.path)
+		if err != nil {
+			log.Errorf("Error reading lease file(%v):%v", file.path, err)
+			continue
+		}
+		file.leases = leaseFileParsers[file.kind](f, now)
+		f.Close()
+		file.modTime = info.ModTime()
+		file.size = info.Size()
+		changed = true
+		log.Debugf("Read %v leases from %v", len(file.leases), file.path)
+	}
+	return changed
+}
+
+func (lf *LeaseFiles) mergeInto(ipToMac map[string]LineOfData, quota QuotaType) {
+	lf.RLock()
+	defer lf.RUnlock()
+	for _, file := range lf.files {
+		for _, lease := range file.leases {
+			line, ok := ipToMac[lease.IP]
+			if !ok {
+				line.IP = lease.IP
+				line.setDefaultQuotas(quota)
+			}
+			if lease.Mac != "" {
+				line.Mac = lease.Mac
+			}
+			if lease.HostName != "" {
+				line.HostName = lease.HostName
+			}
+			if lease.ClientID != "" {
+				line.ClientID = lease.ClientID
+			}
+			if lease.Comment != "" {
+				line.applyComment(lease.Comment, quota)
+			}
+			if line.Mac == "" {
+				line.Mac = line.IP
+			}
+			line.timeout = time.Now()
+			ipToMac[lease.IP] = line
+		}
+	}
+}
+
+func normalizeMac(value string) string {
+	mac, err := net.ParseMAC(value)
+	if err != nil {
+		return ""
+	}
+	return strings.ToUpper(mac.String())
+}
+
+// parseDnsmasqLeases parses dnsmasq.leases: "expiry mac ip hostname client-id", expiry 0 is infinite.
+func parseDnsmasqLeases(r io.Reader, now time.Time) []fileLease {
+	leases := []fileLease{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] == "duid" {
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil || (expiry != 0 && time.Unix(expiry, 0).Before(now)) {
+			continue
+		}
+		if net.ParseIP(fields[2]) == nil {
+			continue
+		}
+		lease := fileLease{
+			IP:  fields[2],
+			Mac: normalizeMac(fields[1]),
+		}
+		if fields[3] != "*" {
+			lease.HostName = fields[3]
+		}
+		if len(fields) > 4 && fields[4] != "*" {
+			lease.ClientID = fields[4]
+		}
+		leases = append(leases, lease)
+	}
+	return leases
+}
+
+// parseISCLeases parses dhcpd.leases of ISC DHCP. The file is a journal, so the later
+// declaration of a lease replaces the earlier one.
+func parseISCLeases(r io.Reader, now time.Time) []fileLease {
+	byIP := map[string]fileLease{}
+	order := []string{}
+	var (
+		lease   fileLease
+		active  bool
+		expired bool
+		inLease bool
+		depth   int
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasSuffix(line, "{") {
+			depth++
+			fields := strings.Fields(line)
+			if depth == 1 && len(fields) == 3 && fields[0] == "lease" {
+				lease = fileLease{IP: fields[1]}
+				active = true
+				expired = false
+				inLease = true
+			}
+			continue
+		}
+		if line == "}" {
+			depth--
+			if depth == 0 && inLease {
+				inLease = false
+				if !active || expired {
+					delete(byIP, lease.IP)
+					continue
+				}
+				if _, ok := byIP[lease.IP]; !ok {
+					order = append(order, lease.IP)
+				}
+				byIP[lease.IP] = lease
+			}
+			continue
+		}
+		if !inLease || depth != 1 {
+			continue
+		}
+		fields := strings.Fields(strings.TrimSuffix(line, ";"))
+		switch {
+		case len(fields) >= 3 && fields[0] == "binding" && fields[1] == "state":
+			active = fields[2] == "active"
+		case len(fields) >= 3 && fields[0] == "hardware":
+			lease.Mac = normalizeMac(fields[2])
+		case len(fields) >= 2 && fields[0] == "client-hostname":
+			lease.HostName = strings.Trim(fields[1], `"`)
+		case len(fields) >= 2 && fields[0] == "uid":
+			lease.ClientID = strings.Trim(strings.Join(fields[1:], " "), `"`)
+		case len(fields) >= 2 && fields[0] == "ends":
+			ends, ok := parseISCTime(fields[1:])
+			expired = ok && ends.Before(now)
+		}
+	}
+
+	leases := []fileLease{}
+	for _, ip := range order {
+		if lease, ok := byIP[ip]; ok {
+			leases = append(leases, lease)
+			delete(byIP, ip)
+		}
+	}
+	return leases
+}
+
+// parseISCTime parses "4 2021/06/22 21:39:13" (UTC), "epoch 1624397953" and "never".
+func parseISCTime(fields []string) (time.Time, bool) {
+	switch {
+	case fields[0] == "never":
+		return time.Time{}, false
+	case fields[0] == "epoch" && len(fields) > 1:
+		sec, err := strconv.ParseInt(fields[1], 10, 64)
+		return time.Unix(sec, 0), err == nil
+	case len(fields) > 2:
+		t, err := time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// parseKeaLeases parses the CSV of the Kea memfile backend. The columns are taken from the header,
+// commas inside the values are escaped as "&#x2c". A "comment" in user_context is used as the lease comment.
+func parseKeaLeases(r io.Reader, now time.Time) []fileLease {
+	byIP := map[string]fileLease{}
+	order := []string{}
+	columns := map[string]int{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		values := strings.Split(scanner.Text(), ",")
+		if len(columns) == 0 {
+			for i, name := range values {
+				columns[strings.TrimSpace(name)] = i
+			}
+			continue
+		}
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(values) {
+				return strings.ReplaceAll(values[i], "&#x2c", ",")
+			}
+			return ""
+		}
+		lease := fileLease{
+			IP:       get("address"),
+			Mac:      normalizeMac(get("hwaddr")),
+			HostName: strings.TrimSuffix(get("hostname"), "."),
+			ClientID: get("client_id"),
+		}
+		if net.ParseIP(lease.IP) == nil {
+			continue
+		}
+		expire, _ := strconv.ParseInt(get("expire"), 10, 64)
+		// state 0 is the default state, 1 - declined, 2 - expired and reclaimed
+		if (get("state") != "0" && get("state") != "") || (expire != 0 && time.Unix(expire, 0).Before(now)) {
+			delete(byIP, lease.IP)
+			continue
+		}
+		userContext := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(get("user_context")), &userContext); err == nil {
+			if comment, ok := userContext["comment"].(string); ok {
+				lease.Comment = comment
+			}
+		}
+		if _, ok := byIP[lease.IP]; !ok {
+			order = append(order, lease.IP)
+		}
+		byIP[lease.IP] = lease
+	}
+
+	leases := []fileLease{}
+	for _, ip := range order {
+		if lease, ok := byIP[ip]; ok {
+			leases = append(leases, lease)
+			delete(byIP, ip)
+		}
+	}
+	return leases
+}
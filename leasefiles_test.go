@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var leaseTestNow = time.Date(2021, 6, 22, 12, 0, 0, 0, time.UTC)
+
+func TestDnsmasqLeases(t *testing.T) {
+	input := `1624370400 aa:bb:cc:00:00:01 192.168.1.10 pc1 01:aa:bb:cc:00:00:01
+0 aa:bb:cc:00:00:02 192.168.1.11 * *
+1624300000 aa:bb:cc:00:00:03 192.168.1.12 expired *
+duid 00:01:00:01:28:3c:1e:56:aa:bb:cc:00:00:ff
+1624370400 aa:bb:cc:00:00:04 not-an-ip broken *
+1624370400 aa:bb:cc:00:00:05 192.168.1.10 pc1-new *
+`
+	want := []fileLease{
+		{IP: "192.168.1.10", Mac: "AA:BB:CC:00:00:01", HostName: "pc1", ClientID: "01:aa:bb:cc:00:00:01"},
+		{IP: "192.168.1.11", Mac: "AA:BB:CC:00:00:02"},
+		{IP: "192.168.1.10", Mac: "AA:BB:CC:00:00:05", HostName: "pc1-new"},
+	}
+	if got := parseDnsmasqLeases(strings.NewReader(input), leaseTestNow); !reflect.DeepEqual(got, want) {
+		t.Errorf("leases\n%+v\ninstead of\n%+v", got, want)
+	}
+}
+
+func TestISCLeases(t *testing.T) {
+	input := `# The format of this file is documented in the dhcpd.leases(5) manual page.
+authoring-byte-order little-endian;
+
+failover peer "dhcp-failover" state {
+  my state normal at 4 2021/06/17 10:00:00;
+  partner state normal at 4 2021/06/17 10:00:00;
+}
+
+lease 192.168.1.10 {
+  starts 2 2021/06/22 09:00:00;
+  ends 2 2021/06/22 21:00:00;
+  binding state active;
+  next binding state free;
+  hardware ethernet aa:bb:cc:00:00:01;
+  uid "\001\252\273\314\000\000\001";
+  client-hostname "pc1";
+}
+lease 192.168.1.11 {
+  starts epoch 1624352400; # 2021/06/22 09:00:00
+  ends never;
+  binding state active;
+  hardware ethernet aa:bb:cc:00:00:02;
+  on expiry {
+    set hostname = "inner";
+  }
+}
+lease 192.168.1.12 {
+  ends 1 2021/06/21 09:00:00;
+  binding state active;
+  hardware ethernet aa:bb:cc:00:00:03;
+}
+lease 192.168.1.13 {
+  ends 2 2021/06/22 21:00:00;
+  binding state abandoned;
+  hardware ethernet aa:bb:cc:00:00:04;
+}
+lease 192.168.1.14 {
+  ends 2 2021/06/22 21:00:00;
+  binding state active;
+  hardware ethernet aa:bb:cc:00:00:05;
+  client-hostname "old";
+}
+lease 192.168.1.10 {
+  ends epoch 1624395600;
+  binding state active;
+  hardware ethernet aa:bb:cc:00:00:06;
+  client-hostname "pc1-new";
+}
+lease 192.168.1.14 {
+  ends 2 2021/06/22 11:00:00;
+  binding state free;
+  hardware ethernet aa:bb:cc:00:00:05;
+}
+`
+	// The later declarations replace the earlier ones: 192.168.1.10 is renewed by another device, 192.168.1.14 is freed
+	want := []fileLease{
+		{IP: "192.168.1.10", Mac: "AA:BB:CC:00:00:06", HostName: "pc1-new"},
+		{IP: "192.168.1.11", Mac: "AA:BB:CC:00:00:02"},
+	}
+	if got := parseISCLeases(strings.NewReader(input), leaseTestNow); !reflect.DeepEqual(got, want) {
+		t.Errorf("leases\n%+v\ninstead of\n%+v", got, want)
+	}
+}
+
+func TestISCTime(t *testing.T) {
+	cases := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"2 2021/06/22 21:00:00", time.Date(2021, 6, 22, 21, 0, 0, 0, time.UTC), true},
+		{"epoch 1624395600", time.Unix(1624395600, 0), true},
+		{"never", time.Time{}, false},
+		{"2 2021/06/22", time.Time{}, false},
+	}
+	for _, c := range cases {
+		got, ok := parseISCTime(strings.Fields(c.value))
+		if ok != c.ok || ok && !got.Equal(c.want) {
+			t.Errorf("%v: %v %v", c.value, got, ok)
+		}
+	}
+}
+
+func TestKeaLeases(t *testing.T) {
+	input := `address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context,pool_id
+192.168.1.10,aa:bb:cc:00:00:01,01:aa:bb:cc:00:00:01,3600,1624370400,1,0,0,pc1.example.com.,0,{ "comment": "name=Ivan/quotadaily=100"&#x2c "source": "kea" },0
+192.168.1.11,aa:bb:cc:00:00:02,,3600,1624300000,1,0,0,expired,0,,0
+192.168.1.12,aa:bb:cc:00:00:03,,3600,1624370400,1,0,0,declined,1,,0
+192.168.1.13,aa:bb:cc:00:00:04,,3600,1624370400,1,0,0,reclaimed,2,,0
+192.168.1.14,aa:bb:cc:00:00:05,,3600,1624370400,1,0,0,first,0,,0
+not-an-ip,aa:bb:cc:00:00:06,,3600,1624370400,1,0,0,broken,0,,0
+192.168.1.14,aa:bb:cc:00:00:07,,3600,1624370400,1,0,0,second,0,,0
+192.168.1.10,aa:bb:cc:00:00:01,,3600,1624300000,1,0,0,pc1,2,,0
+192.168.1.15,aa:bb:cc:00:00:08,,0,0,1,0,0,infinite,,,0
+`
+	// The lease of 192.168.1.10 is reclaimed by the later line, 192.168.1.14 is taken by another device
+	want := []fileLease{
+		{IP: "192.168.1.14", Mac: "AA:BB:CC:00:00:07", HostName: "second"},
+		{IP: "192.168.1.15", Mac: "AA:BB:CC:00:00:08", HostName: "infinite"},
+	}
+	if got := parseKeaLeases(strings.NewReader(input), leaseTestNow); !reflect.DeepEqual(got, want) {
+		t.Errorf("leases\n%+v\ninstead of\n%+v", got, want)
+	}
+
+	first := strings.SplitN(input, "\n", 3)
+	got := parseKeaLeases(strings.NewReader(first[0]+"\n"+first[1]+"\n"), leaseTestNow)
+	if len(got) != 1 || got[0].Comment != "name=Ivan/quotadaily=100" || got[0].HostName != "pc1.example.com" || got[0].ClientID != "01:aa:bb:cc:00:00:01" {
+		t.Errorf("lease with the user context %+v", got)
+	}
+}
+
+func TestLeaseFilesRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	if err := ioutil.WriteFile(path, []byte("0 aa:bb:cc:00:00:01 192.168.1.10 pc1 *\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lf := newLeaseFiles([]string{"dnsmasq:" + path, "unknown:/tmp/x", ""})
+	if len(lf.files) != 1 {
+		t.Fatalf("files %+v", lf.files)
+	}
+	if !lf.read(leaseTestNow) {
+		t.Fatal("the file is not read")
+	}
+	if lf.read(leaseTestNow) {
+		t.Error("the file is read again without changes")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("0 aa:bb:cc:00:00:02 192.168.1.10 pc2 *\n0 aa:bb:cc:00:00:03 192.168.1.11 pc3 *\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if !lf.read(leaseTestNow) {
+		t.Fatal("the changed file is not read")
+	}
+	ipToMac := map[string]LineOfData{}
+	lf.mergeInto(ipToMac, QuotaType{DailyQuota: 10})
+	if line := ipToMac["192.168.1.10"]; line.Mac != "AA:BB:CC:00:00:02" || line.HostName != "pc2" || line.DailyQuota != 10 {
+		t.Errorf("line %+v", line)
+	}
+	if len(ipToMac) != 2 {
+		t.Errorf("table %+v", ipToMac)
+	}
+}
@@ -15,7 +15,17 @@ func main() {
 
 	cfg := newConfig()
 
+	if cfg.OUIUpdate != "" {
+		if err := updateOUI(cfg.OUIUpdate, cfg.OUIFile); err != nil {
+			log.Fatalf("Error updating the OUI database:%v", err)
+		}
+		return
+	}
+
 	cache.cache = make(map[string]cacheRecord)
+	if cfg.UseReverseDNS {
+		cache.startReverseDNS(cfg)
+	}
 
 	data := NewTransport(cfg)
 	/*Creating a channel to intercept the program end signal*/
@@ -26,11 +36,56 @@ func main() {
 	data.MonthlyQuota = uint64(cfg.DefaultQuotaMonthly * cfg.SizeOneMegabyte)
 
 	go data.loopGetDataFromMT()
+	if cfg.UseHotSpot && data.connectedToMT() {
+		go data.loopGetDataFromHotSpot()
+	}
+	if cfg.DNSTapAddr != "" {
+		go data.listenDNSTap()
+	}
+	if cfg.RadiusAddr != "" {
+		go data.listenRadius()
+		go data.loopExpireRadius()
+	}
+	if cfg.UseWireless && data.connectedToMT() {
+		go data.loopGetDataFromWireless()
+	}
+	if cfg.UseNAT && data.connectedToMT() {
+		go data.loopGetNATFromMT()
+	}
+	if cfg.UseDNSCache && data.connectedToMT() {
+		go data.loopGetDNSCacheFromMT()
+	}
+	if len(data.leaseFiles.files) > 0 {
+		go data.loopReadLeaseFiles()
+	}
+	if cfg.UseLocalNeighbors {
+		go data.watchLocalNeighbors()
+	}
+	if len(data.snmp.exporters) > 0 {
+		go data.loopPollSNMP()
+	}
+	if cfg.StaticDevices != "" {
+		go data.loopReadStaticDevices()
+	}
+	if cfg.LDAPURL != "" {
+		go data.loopLookupDirectory()
+	}
+	if cfg.IdentityFile != "" {
+		go data.loopSaveIdentities()
+	}
+	if cfg.GeoIPFile != "" {
+		go data.geoIP.loopReload()
+	}
+	if cfg.ASNFile != "" {
+		go data.asnDB.loopReload()
+	}
 
 	http.HandleFunc("/", logreq(handleIndex))
 	http.HandleFunc("/getmac", logreq(data.handlerGetMac()))
 	http.HandleFunc("/setstatusdevices", logreq(data.handlerSetStatusDevices))
 	http.HandleFunc("/getstatusdevices", logreq(data.handlerGetStatusDevices))
+	http.HandleFunc("/gethotspotsessions", logreq(data.handlerGetHotSpotSessions))
+	http.HandleFunc("/getremote", logreq(data.handlerGetRemote))
 
 	log.Infof("gonsquid listens to:%v", cfg.BindAddr)
 
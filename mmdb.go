@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reader of MaxMind DB files (GeoLite2, DB-IP and others in the same format).
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+const (
+	mmdbPointer   = 1
+	mmdbString    = 2
+	mmdbDouble    = 3
+	mmdbBytes     = 4
+	mmdbUint16    = 5
+	mmdbUint32    = 6
+	mmdbMap       = 7
+	mmdbInt32     = 8
+	mmdbUint64    = 9
+	mmdbUint128   = 10
+	mmdbArray     = 11
+	mmdbContainer = 12
+	mmdbEnd       = 13
+	mmdbBool      = 14
+	mmdbFloat     = 15
+
+	// The data of GeoIP databases is nested a few levels, the limit stops the loops of pointers
+	mmdbMaxDepth = 64
+)
+
+type mmdbReader struct {
+	buf        []byte
+	nodeCount  int
+	recordSize int
+	ipVersion  int
+	treeSize   int
+	ipv4Start  int
+}
+
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	start := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if start < 0 {
+		return nil, errors.New("mmdb: metadata is not found")
+	}
+	start += len(mmdbMetadataMarker)
+	metadata, _, err := (&mmdbDecoder{buf: buf[start:]}).decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := metadata.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("mmdb: wrong metadata")
+	}
+	r := &mmdbReader{
+		buf:        buf,
+		nodeCount:  int(mmdbUint(m["node_count"])),
+		recordSize: int(mmdbUint(m["record_size"])),
+		ipVersion:  int(mmdbUint(m["ip_version"])),
+	}
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("mmdb: unsupported record size %v", r.recordSize)
+	}
+	r.treeSize = r.nodeCount * r.recordSize / 4
+	if r.treeSize+16 > start {
+		return nil, errors.New("mmdb: wrong search tree size")
+	}
+	if r.ipVersion == 6 {
+		// IPv4 addresses are in ::/96
+		node := 0
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+func mmdbUint(v interface{}) uint64 {
+	n, _ := v.(uint64)
+	return n
+}
+
+func (r *mmdbReader) record(node, bit int) int {
+	b := r.buf[node*r.recordSize/4:]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	case 28:
+		if bit == 0 {
+			return int(b[3]&0xf0)<<20 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+		}
+		return int(b[3]&0x0f)<<24 | int(b[4])<<16 | int(b[5])<<8 | int(b[6])
+	default:
+		return int(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup returns the data of the IP address, nil if the address is not in the database.
+func (r *mmdbReader) lookup(ip net.IP) (interface{}, error) {
+	if ip == nil {
+		return nil, nil
+	}
+	node := 0
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 32
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		bit := int(ip[i/8]>>(7-uint(i%8))) & 1
+		node = r.record(node, bit)
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, errors.New("mmdb: wrong search tree")
+	}
+	decoder := &mmdbDecoder{buf: r.buf[r.treeSize+16:]}
+	value, _, err := decoder.decode(node-r.nodeCount-16, 0)
+	return value, err
+}
+
+type mmdbDecoder struct {
+	buf []byte
+}
+
+func (d *mmdbDecoder) bytes(offset, size int) ([]byte, error) {
+	if offset < 0 || size < 0 || offset+size > len(d.buf) {
+		return nil, errors.New("mmdb: data out of range")
+	}
+	return d.buf[offset : offset+size], nil
+}
+
+func (d *mmdbDecoder) control(offset int) (kind, size, next int, err error) {
+	b, err := d.bytes(offset, 1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	kind, size, next = int(b[0]>>5), int(b[0]&0x1f), offset+1
+	if kind == mmdbPointer {
+		return kind, size, next, nil
+	}
+	if kind == 0 {
+		ext, err := d.bytes(next, 1)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		kind, next = 7+int(ext[0]), next+1
+	}
+	if size >= 29 {
+		n := size - 28
+		ext, err := d.bytes(next, n)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		v := 0
+		for _, c := range ext {
+			v = v<<8 | int(c)
+		}
+		size = []int{29, 285, 65821}[n-1] + v
+		next += n
+	}
+	return kind, size, next, nil
+}
+
+// decode decodes the value at the offset and returns the offset after it. The depth is the number
+// of the maps, arrays and pointers the value is in.
+func (d *mmdbDecoder) decode(offset, depth int) (interface{}, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("mmdb: data is nested too deep")
+	}
+	kind, size, next, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Every element of a map or an array takes a byte at least
+	if (kind == mmdbMap || kind == mmdbArray) && size > len(d.buf)-next {
+		return nil, 0, errors.New("mmdb: data out of range")
+	}
+	switch kind {
+	case mmdbPointer:
+		target, after, err := d.pointer(size, next)
+		if err != nil {
+			return nil, 0, err
+		}
+		if kind, _, _, err := d.control(target); err == nil && kind == mmdbPointer {
+			return nil, 0, errors.New("mmdb: pointer to a pointer")
+		}
+		value, _, err := d.decode(target, depth+1)
+		return value, after, err
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			key, n, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, n, err := d.decode(n, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, _ := key.(string)
+			m[k] = value
+			next = n
+		}
+		return m, next, nil
+	case mmdbArray:
+		a := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			value, n, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			next = n
+		}
+		return a, next, nil
+	case mmdbBool:
+		return size != 0, next, nil
+	case mmdbContainer, mmdbEnd:
+		return nil, next, nil
+	}
+	b, err := d.bytes(next, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	next += size
+	switch kind {
+	case mmdbString:
+		return string(b), next, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("mmdb: wrong double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("mmdb: wrong float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case mmdbInt32:
+		var v int32
+		for _, c := range b {
+			v = v<<8 | int32(c)
+		}
+		return int64(v), next, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, next, nil
+	default:
+		// bytes and uint128
+		return b, next, nil
+	}
+}
+
+func (d *mmdbDecoder) pointer(size, offset int) (target, next int, err error) {
+	n := (size>>3)&3 + 1
+	b, err := d.bytes(offset, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	v := size & 7
+	if n == 4 {
+		v = 0
+	}
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+	return v, offset + n, nil
+}
+
+// mmdbPath returns the value by the keys of the nested maps, e.g. "country", "iso_code".
+func mmdbPath(value interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+func mmdbPathString(value interface{}, keys ...string) string {
+	s, _ := mmdbPath(value, keys...).(string)
+	return s
+}
+
+// mmdbFile is a database reloaded when the file changes.
+type mmdbFile struct {
+	path    string
+	modTime time.Time
+	reader  *mmdbReader
+	sync.RWMutex
+}
+
+func newMMDBFile(path string) *mmdbFile {
+	f := &mmdbFile{path: path}
+	if path != "" {
+		f.reload()
+	}
+	return f
+}
+
+// reload reads the file again if it has changed.
+func (f *mmdbFile) reload() bool {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		log.Errorf("Error reading %v:%v", f.path, err)
+		return false
+	}
+	f.RLock()
+	same := info.ModTime().Equal(f.modTime)
+	f.RUnlock()
+	if same {
+		return false
+	}
+	reader, err := openMMDB(f.path)
+	if err != nil {
+		// The old database is used until the file is fixed
+		log.Errorf("Error reading %v:%v", f.path, err)
+		return false
+	}
+	f.Lock()
+	f.reader, f.modTime = reader, info.ModTime()
+	f.Unlock()
+	log.Infof("Loaded %v", f.path)
+	return true
+}
+
+func (f *mmdbFile) lookup(ip string) interface{} {
+	f.RLock()
+	reader := f.reader
+	f.RUnlock()
+	if reader == nil {
+		return nil
+	}
+	value, err := reader.lookup(net.ParseIP(ip))
+	if err != nil {
+		log.Tracef("Error looking up %v in %v:%v", ip, f.path, err)
+	}
+	return value
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// mmdbControl encodes the control byte of the type and the size, the size is less than 285.
+func mmdbControl(kind, size int) []byte {
+	b := []byte{}
+	if size < 29 {
+		b = append(b, byte(size))
+	} else {
+		b = append(b, 29, byte(size-29))
+	}
+	if kind > 7 {
+		return append([]byte{b[0], byte(kind - 7)}, b[1:]...)
+	}
+	b[0] |= byte(kind << 5)
+	return b
+}
+
+func mmdbTestString(s string) []byte {
+	return append(mmdbControl(mmdbString, len(s)), s...)
+}
+
+func mmdbTestUint(kind int, v uint64) []byte {
+	b := []byte{}
+	for ; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	return append(mmdbControl(kind, len(b)), b...)
+}
+
+func mmdbTestMap(pairs ...[]byte) []byte {
+	b := mmdbControl(mmdbMap, len(pairs)/2)
+	for _, pair := range pairs {
+		b = append(b, pair...)
+	}
+	return b
+}
+
+// mmdbTestPointer encodes a pointer with 11 bits of the offset.
+func mmdbTestPointer(offset int) []byte {
+	return []byte{byte(mmdbPointer<<5 | offset>>8&7), byte(offset)}
+}
+
+type mmdbTestNetwork struct {
+	cidr string
+	data int
+}
+
+// mmdbTestFile writes the database of the networks, the records point to the offsets in the data section.
+func mmdbTestFile(t *testing.T, recordSize, ipVersion int, networks []mmdbTestNetwork, data []byte) string {
+	const empty, leaf = -1, -2
+	nodes := [][2]int{{empty, empty}}
+	leaves := map[[2]int]int{}
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip, ones := ipNet.IP.To16(), 0
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			ones, _ = ipNet.Mask.Size()
+			if ipVersion == 4 {
+				ip = ip4
+			} else {
+				ip, ones = append(make(net.IP, 12), ip4...), ones+96
+			}
+		} else {
+			ones, _ = ipNet.Mask.Size()
+		}
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i/8]>>(7-uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[node][bit] = leaf
+				leaves[[2]int{node, bit}] = network.data
+				break
+			}
+			if nodes[node][bit] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	tree := make([]byte, nodeCount*recordSize/4)
+	for i, node := range nodes {
+		for bit, record := range node {
+			switch record {
+			case empty:
+				record = nodeCount
+			case leaf:
+				record = nodeCount + 16 + leaves[[2]int{i, bit}]
+			}
+			b := tree[i*recordSize/4:]
+			switch recordSize {
+			case 24:
+				b[bit*3], b[bit*3+1], b[bit*3+2] = byte(record>>16), byte(record>>8), byte(record)
+			case 28:
+				if bit == 0 {
+					b[0], b[1], b[2] = byte(record>>16), byte(record>>8), byte(record)
+					b[3] |= byte(record>>20) & 0xf0
+				} else {
+					b[4], b[5], b[6] = byte(record>>16), byte(record>>8), byte(record)
+					b[3] |= byte(record>>24) & 0x0f
+				}
+			case 32:
+				binary.BigEndian.PutUint32(b[bit*4:], uint32(record))
+			}
+		}
+	}
+
+	metadata := mmdbTestMap(
+		mmdbTestString("node_count"), mmdbTestUint(mmdbUint32, uint64(nodeCount)),
+		mmdbTestString("record_size"), mmdbTestUint(mmdbUint16, uint64(recordSize)),
+		mmdbTestString("ip_version"), mmdbTestUint(mmdbUint16, uint64(ipVersion)),
+	)
+	file := append(append(append(append(tree, make([]byte, 16)...), data...), mmdbMetadataMarker...), metadata...)
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := ioutil.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMMDBLookup(t *testing.T) {
+	country := func(code string) []byte {
+		return mmdbTestMap(mmdbTestString("country"), mmdbTestMap(mmdbTestString("iso_code"), mmdbTestString(code)))
+	}
+	data := country("AA")
+	second := len(data)
+	// The second network shares the map of the first one by a pointer
+	data = append(data, mmdbTestMap(mmdbTestString("country"), mmdbTestPointer(1+len(mmdbTestString("country"))))...)
+	third := len(data)
+	data = append(data, country("CC")...)
+
+	for _, recordSize := range []int{24, 28, 32} {
+		for _, ipVersion := range []int{4, 6} {
+			networks := []mmdbTestNetwork{{"10.0.0.0/8", 0}, {"192.168.1.0/24", second}}
+			if ipVersion == 6 {
+				networks = append(networks, mmdbTestNetwork{"2001:db8::/32", third})
+			}
+			r, err := openMMDB(mmdbTestFile(t, recordSize, ipVersion, networks, data))
+			if err != nil {
+				t.Fatalf("%v/%v: %v", recordSize, ipVersion, err)
+			}
+			want := map[string]string{"10.20.30.40": "AA", "192.168.1.7": "AA", "192.168.2.7": "", "11.0.0.1": ""}
+			if ipVersion == 6 {
+				want["2001:db8::1"], want["2001:db9::1"] = "CC", ""
+			}
+			for ip, code := range want {
+				value, err := r.lookup(net.ParseIP(ip))
+				if err != nil {
+					t.Errorf("%v/%v %v: %v", recordSize, ipVersion, ip, err)
+				}
+				if got := mmdbPathString(value, "country", "iso_code"); got != code {
+					t.Errorf("%v/%v %v: %q instead of %q", recordSize, ipVersion, ip, got, code)
+				}
+			}
+		}
+	}
+}
+
+// The high bits of 28-bit records are in the middle byte of the node.
+func TestMMDBRecord28(t *testing.T) {
+	r := &mmdbReader{buf: []byte{0x12, 0x34, 0x56, 0xab, 0xcd, 0xef, 0x01}, recordSize: 28}
+	if left, right := r.record(0, 0), r.record(0, 1); left != 0xa123456 || right != 0xbcdef01 {
+		t.Errorf("records %x %x", left, right)
+	}
+}
+
+func TestMMDBDecodeBroken(t *testing.T) {
+	broken := map[string][]byte{
+		"pointer to a pointer":  append(mmdbTestPointer(2), mmdbTestPointer(0)...),
+		"pointer to itself":     mmdbTestPointer(0),
+		"map with a loop":       mmdbTestMap(mmdbTestString("a"), mmdbTestPointer(0)),
+		"array over the buffer": append(mmdbControl(mmdbArray, 200), 1),
+		"string over the end":   mmdbControl(mmdbString, 10),
+	}
+	for name, data := range broken {
+		if _, _, err := (&mmdbDecoder{buf: data}).decode(0, 0); err == nil {
+			t.Errorf("%v: no error", name)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type natEndpoint struct {
+	ip   uint32
+	port uint16
+	seen time.Time
+}
+
+// NAT maps the translated address and port of the router to the internal ones by the snapshots of the connection table,
+// so the flows exported after source NAT (e.g. Traffic Flow on the WAN interface) are credited to the internal devices.
+// Only NetFlow v5 is decoded, so the NAT event fields of v9/IPFIX are not used.
+type NAT struct {
+	// protocol/public ip:port/remote ip:port -> internal ip:port
+	connections map[string]natEndpoint
+	sync.RWMutex
+}
+
+func natKey(protocol uint8, localIP uint32, localPort uint16, remoteIP uint32, remotePort uint16) string {
+	return fmt.Sprintf("%v/%v:%v/%v:%v", protocol, localIP, localPort, remoteIP, remotePort)
+}
+
+func (data *Transport) loopGetNATFromMT() {
+	for {
+		data.getNATFromMT()
+		time.Sleep(parseDurationOr(cfg.NATInterval, 30*time.Second))
+	}
+}
+
+func (data *Transport) getNATFromMT() {
+	now := time.Now()
+	history := parseDurationOr(cfg.NATHistory, 30*time.Minute)
+	count := 0
+	data.pollRouters("the connection table", func(r *router) error {
+		reply, err := r.client.Run("/ip/firewall/connection/print",
+			"=.proplist=protocol,src-address,reply-src-address,reply-dst-address")
+		if err != nil {
+			return err
+		}
+		data.nat.Lock()
+		defer data.nat.Unlock()
+		if data.nat.connections == nil {
+			data.nat.connections = map[string]natEndpoint{}
+		}
+		for _, re := range reply.Re {
+			var protocol uint8
+			switch re.Map["protocol"] {
+			case "tcp":
+				protocol = 6
+			case "udp":
+				protocol = 17
+			default:
+				continue
+			}
+			internalIP, internalPort, err := parseIPPort(re.Map["src-address"])
+			if err != nil {
+				continue
+			}
+			// The reply goes from the remote host to the translated address of the router
+			remoteIP, remotePort, err := parseIPPort(re.Map["reply-src-address"])
+			if err != nil {
+				continue
+			}
+			publicIP, publicPort, err := parseIPPort(re.Map["reply-dst-address"])
+			if err != nil || (publicIP == internalIP && publicPort == internalPort) {
+				continue
+			}
+			data.nat.connections[natKey(protocol, publicIP, publicPort, remoteIP, remotePort)] = natEndpoint{ip: internalIP, port: internalPort, seen: now}
+			count++
+		}
+		return nil
+	})
+	// The connections of a router which failed are kept for the history too
+	data.nat.Lock()
+	for key, endpoint := range data.nat.connections {
+		if now.Sub(endpoint.seen) > history {
+			delete(data.nat.connections, key)
+		}
+	}
+	data.nat.Unlock()
+	log.Tracef("Get %v translated connections from mikrotik", count)
+}
+
+func parseIPPort(address string) (uint32, uint16, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0, 0, err
+	}
+	ip := net.ParseIP(host).To4()
+	if ip == nil {
+		return 0, 0, fmt.Errorf("not an IPv4 address: %v", host)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3]), uint16(port), nil
+}
+
+// translate replaces the public address of the router in the flow with the internal address of the connection.
+func (n *NAT) translate(binRecord *binaryRecord) bool {
+	n.RLock()
+	defer n.RUnlock()
+	if len(n.connections) == 0 {
+		return false
+	}
+	// Outgoing: from the public address to the remote host
+	key := natKey(binRecord.Protocol, binRecord.Ipv4SrcAddrInt, binRecord.L4SrcPort, binRecord.Ipv4DstAddrInt, binRecord.L4DstPort)
+	if endpoint, ok := n.connections[key]; ok {
+		binRecord.Ipv4SrcAddrInt, binRecord.L4SrcPort = endpoint.ip, endpoint.port
+		return true
+	}
+	// Incoming: from the remote host to the public address
+	key = natKey(binRecord.Protocol, binRecord.Ipv4DstAddrInt, binRecord.L4DstPort, binRecord.Ipv4SrcAddrInt, binRecord.L4SrcPort)
+	if endpoint, ok := n.connections[key]; ok {
+		binRecord.Ipv4DstAddrInt, binRecord.L4DstPort = endpoint.ip, endpoint.port
+		return true
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-routeros/routeros"
+)
+
+func testIPPort(t *testing.T, address string) (uint32, uint16) {
+	ip, port, err := parseIPPort(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ip, port
+}
+
+func TestNATTranslate(t *testing.T) {
+	saved := cfg
+	defer func() { cfg = saved }()
+	cfg.NATHistory = "30m"
+
+	var down bool
+	r := fakeRouter("gw", &down, map[string]*routeros.Reply{
+		"/ip/firewall/connection/print": testReply(
+			// srcnat of 192.168.1.10:50000 to the public 203.0.113.1:40000
+			map[string]string{"protocol": "tcp", "src-address": "192.168.1.10:50000", "reply-src-address": "198.51.100.7:443", "reply-dst-address": "203.0.113.1:40000"},
+			map[string]string{"protocol": "udp", "src-address": "192.168.1.11:5353", "reply-src-address": "198.51.100.8:53", "reply-dst-address": "203.0.113.1:41000"},
+			// Not translated
+			map[string]string{"protocol": "tcp", "src-address": "192.168.1.12:50001", "reply-src-address": "192.168.2.1:22", "reply-dst-address": "192.168.1.12:50001"},
+			map[string]string{"protocol": "icmp", "src-address": "192.168.1.13", "reply-src-address": "198.51.100.9", "reply-dst-address": "203.0.113.1"},
+		),
+	})
+	data := &Transport{routers: []*router{r}}
+	expiredIP, expiredPort := testIPPort(t, "192.168.1.14:50002")
+	publicIP, _ := testIPPort(t, "203.0.113.1:0")
+	remoteIP, _ := testIPPort(t, "198.51.100.10:0")
+	data.nat.connections = map[string]natEndpoint{
+		natKey(6, publicIP, 42000, remoteIP, 443): {ip: expiredIP, port: expiredPort, seen: time.Now().Add(-time.Hour)},
+	}
+	data.getNATFromMT()
+	if len(data.nat.connections) != 2 {
+		t.Errorf("connections %v", data.nat.connections)
+	}
+
+	flow := func(protocol uint8, src, dst string) binaryRecord {
+		record := binaryRecord{Protocol: protocol}
+		record.Ipv4SrcAddrInt, record.L4SrcPort = testIPPort(t, src)
+		record.Ipv4DstAddrInt, record.L4DstPort = testIPPort(t, dst)
+		return record
+	}
+	cases := []struct {
+		name       string
+		flow, want binaryRecord
+		translated bool
+	}{
+		{"outgoing", flow(6, "203.0.113.1:40000", "198.51.100.7:443"), flow(6, "192.168.1.10:50000", "198.51.100.7:443"), true},
+		{"incoming", flow(6, "198.51.100.7:443", "203.0.113.1:40000"), flow(6, "198.51.100.7:443", "192.168.1.10:50000"), true},
+		{"udp", flow(17, "198.51.100.8:53", "203.0.113.1:41000"), flow(17, "198.51.100.8:53", "192.168.1.11:5353"), true},
+		{"other protocol", flow(17, "203.0.113.1:40000", "198.51.100.7:443"), flow(17, "203.0.113.1:40000", "198.51.100.7:443"), false},
+		{"expired", flow(6, "203.0.113.1:42000", "198.51.100.10:443"), flow(6, "203.0.113.1:42000", "198.51.100.10:443"), false},
+		{"icmp", flow(1, "203.0.113.1:0", "198.51.100.9:0"), flow(1, "203.0.113.1:0", "198.51.100.9:0"), false},
+	}
+	for _, c := range cases {
+		record := c.flow
+		if translated := data.nat.translate(&record); translated != c.translated || record != c.want {
+			t.Errorf("%v: translated %v, %+v", c.name, translated, record)
+		}
+	}
+
+	// The connections of a router which is down are kept for the history
+	down = true
+	data.getNATFromMT()
+	if len(data.nat.connections) != 2 {
+		t.Errorf("connections of the router which is down %v", data.nat.connections)
+	}
+}
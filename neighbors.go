@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type neighbor struct {
+	Mac,
+	Interface string
+}
+
+// Neighbors is the neighbor (ARP/NDP) table of the kernel, used when gonsquid runs on the gateway itself.
+type Neighbors struct {
+	table map[string]neighbor
+	sync.RWMutex
+}
+
+// watchLocalNeighbors loads the neighbor table over netlink and follows its changes.
+// Where netlink is not available, /proc/net/arp is read periodically.
+func (data *Transport) watchLocalNeighbors() {
+	for {
+		table, err := dumpNeighbors()
+		if err != nil {
+			log.Warningf("Error getting the neighbor table over netlink, /proc/net/arp is used:%v", err)
+			data.loopReadProcNetArp()
+			return
+		}
+		data.localNeighbors.replace(table)
+		data.mergeLocalNeighbors()
+		log.Debugf("Get %v neighbors from the kernel", len(table))
+
+		err = subscribeNeighbors(func(ip string, n neighbor, deleted bool) {
+			data.localNeighbors.update(ip, n, deleted)
+			if !deleted {
+				data.mergeLocalNeighbors()
+			}
+		})
+		log.Errorf("Error following the changes of the neighbor table:%v", err)
+		time.Sleep(15 * time.Second)
+	}
+}
+
+func (data *Transport) loopReadProcNetArp() {
+	for {
+		f, err := os.Open("/proc/net/arp")
+		if err != nil {
+			log.Errorf("Error reading /proc/net/arp:%v", err)
+		} else {
+			data.localNeighbors.replace(parseProcNetArp(f))
+			f.Close()
+			data.mergeLocalNeighbors()
+		}
+
+		interval, err := time.ParseDuration(cfg.LocalNeighborsInterval)
+		if err != nil {
+			interval = 10 * time.Second
+		}
+		time.Sleep(interval)
+	}
+}
+
+// parseProcNetArp parses /proc/net/arp: "IP address, HW type, Flags, HW address, Mask, Device".
+func parseProcNetArp(r io.Reader) map[string]neighbor {
+	table := map[string]neighbor{}
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// flags 0x0 is an incomplete entry
+		if len(fields) < 6 || fields[2] == "0x0" {
+			continue
+		}
+		mac := normalizeMac(fields[3])
+		if mac == "" || mac == "00:00:00:00:00:00" {
+			continue
+		}
+		table[fields[0]] = neighbor{Mac: mac, Interface: fields[5]}
+	}
+	return table
+}
+
+func (data *Transport) mergeLocalNeighbors() {
+	data.Lock()
+	data.localNeighbors.mergeInto(data.ipToMac, data.QuotaType)
+	data.Unlock()
+}
+
+func (n *Neighbors) replace(table map[string]neighbor) {
+	n.Lock()
+	n.table = table
+	n.Unlock()
+}
+
+func (n *Neighbors) update(ip string, entry neighbor, deleted bool) {
+	n.Lock()
+	defer n.Unlock()
+	if n.table == nil {
+		n.table = map[string]neighbor{}
+	}
+	if deleted {
+		delete(n.table, ip)
+	} else {
+		n.table[ip] = entry
+	}
+}
+
+// apply puts the MAC address from the neighbor table into the line, which does not have one.
+func (n *Neighbors) apply(line *LineOfData) bool {
+	n.RLock()
+	defer n.RUnlock()
+	entry, ok := n.table[line.IP]
+	if !ok {
+		return false
+	}
+	if line.Mac == "" || line.Mac == line.IP {
+		line.Mac = entry.Mac
+	}
+	line.Interface = entry.Interface
+	return true
+}
+
+func (n *Neighbors) mergeInto(ipToMac map[string]LineOfData, quota QuotaType) {
+	n.RLock()
+	ips := make([]string, 0, len(n.table))
+	for ip := range n.table {
+		ips = append(ips, ip)
+	}
+	n.RUnlock()
+
+	mergeIPs(ipToMac, ips, quota, func(line *LineOfData) bool {
+		return n.apply(line)
+	})
+}
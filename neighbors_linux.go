@@ -0,0 +1,136 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	rtmgrpNeigh = 0x4
+	sizeofNdMsg = 12
+
+	ndaDst    = 1
+	ndaLladdr = 2
+
+	nudIncomplete = 0x01
+	nudFailed     = 0x20
+	nudNoARP      = 0x40
+)
+
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	var x uint16 = 1
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+func dumpNeighbors() (map[string]neighbor, error) {
+	buf, err := syscall.NetlinkRIB(syscall.RTM_GETNEIGH, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(buf)
+	if err != nil {
+		return nil, err
+	}
+	table := map[string]neighbor{}
+	for _, msg := range msgs {
+		if msg.Header.Type != syscall.RTM_NEWNEIGH {
+			continue
+		}
+		if ip, entry, ok := parseNeighborMessage(msg.Data); ok {
+			table[ip] = entry
+		}
+	}
+	return table, nil
+}
+
+// subscribeNeighbors calls update on every change of the neighbor table until an error occurs.
+func subscribeNeighbors(update func(ip string, n neighbor, deleted bool)) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: rtmgrpNeigh}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			switch msg.Header.Type {
+			case syscall.RTM_NEWNEIGH:
+				if ip, entry, ok := parseNeighborMessage(msg.Data); ok {
+					update(ip, entry, false)
+				} else if ip != "" {
+					update(ip, entry, true)
+				}
+			case syscall.RTM_DELNEIGH:
+				if ip, entry, _ := parseNeighborMessage(msg.Data); ip != "" {
+					update(ip, entry, true)
+				}
+			}
+		}
+	}
+}
+
+// parseNeighborMessage parses struct ndmsg and its attributes. ok is false for the entries
+// without a usable MAC address (incomplete, failed, noarp).
+func parseNeighborMessage(b []byte) (ip string, entry neighbor, ok bool) {
+	if len(b) < sizeofNdMsg {
+		return "", entry, false
+	}
+	ifindex := int32(nativeEndian.Uint32(b[4:8]))
+	state := nativeEndian.Uint16(b[8:10])
+
+	var mac net.HardwareAddr
+	for attrs := b[sizeofNdMsg:]; len(attrs) >= 4; {
+		length := int(nativeEndian.Uint16(attrs[0:2]))
+		kind := nativeEndian.Uint16(attrs[2:4])
+		if length < 4 || length > len(attrs) {
+			break
+		}
+		value := attrs[4:length]
+		switch kind {
+		case ndaDst:
+			ip = net.IP(value).String()
+		case ndaLladdr:
+			mac = net.HardwareAddr(value)
+		}
+		// attributes are aligned to 4 bytes
+		aligned := (length + 3) &^ 3
+		if aligned > len(attrs) {
+			break
+		}
+		attrs = attrs[aligned:]
+	}
+
+	if iface, err := net.InterfaceByIndex(int(ifindex)); err == nil {
+		entry.Interface = iface.Name
+	}
+	if len(mac) != 6 || state&(nudIncomplete|nudFailed|nudNoARP) != 0 {
+		return ip, entry, false
+	}
+	entry.Mac = strings.ToUpper(mac.String())
+	return ip, entry, ip != ""
+}
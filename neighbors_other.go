@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+var errNetlinkNotSupported = errors.New("netlink is only supported on Linux")
+
+func dumpNeighbors() (map[string]neighbor, error) {
+	return nil, errNetlinkNotSupported
+}
+
+func subscribeNeighbors(update func(ip string, n neighbor, deleted bool)) error {
+	return errNetlinkNotSupported
+}
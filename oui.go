@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//go:generate go run gen_oui.go oui.txt
+
+// The type of a device guessed by the vendor, when the comment does not set one. Only the
+// divisions making printers are listed, Kyocera Display or Kyocera Communications are not.
+var ouiTypes = []struct{ keyword, typeD string }{
+	{"brother", "prn"},
+	{"kyocera document", "prn"},
+	{"kyocera mita", "prn"},
+	{"ricoh", "prn"},
+	{"canon", "prn"},
+	{"xerox", "prn"},
+	{"epson", "prn"},
+	{"konica", "prn"},
+	{"lexmark", "prn"},
+	{"yealink", "tel"},
+	{"grandstream", "tel"},
+	{"polycom", "tel"},
+	{"vmware", "srv"},
+	{"xensource", "srv"},
+	{"parallels", "srv"},
+	{"synology", "srv"},
+	{"qnap", "srv"},
+}
+
+// OUI is the database of the vendors by the prefixes of MAC addresses (MA-L, MA-M and MA-S).
+type OUI struct {
+	vendors map[string]string
+}
+
+func newOUI(path string) *OUI {
+	oui := &OUI{vendors: registryOUI}
+	if path == "" {
+		return oui
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Debugf("The OUI database(%v) is not loaded, the bundled registry is used:%v", path, err)
+		return oui
+	}
+	defer f.Close()
+	vendors, err := parseOUI(f)
+	if err != nil {
+		log.Errorf("Error reading the OUI database(%v):%v", path, err)
+		return oui
+	}
+	log.Debugf("Loaded %v vendors from %v", len(vendors), path)
+	// The prefixes which are no longer in the newer registry are kept
+	for prefix, vendor := range registryOUI {
+		if _, ok := vendors[prefix]; !ok {
+			vendors[prefix] = vendor
+		}
+	}
+	oui.vendors = vendors
+	return oui
+}
+
+// updateOUI converts the IEEE database (oui.txt or oui.csv, mam.csv, oui36.csv) into the file read at start.
+func updateOUI(source, path string) error {
+	vendors := map[string]string{}
+	for _, name := range strings.Split(source, ",") {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		list, err := parseOUI(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%v:%v", name, err)
+		}
+		for prefix, vendor := range list {
+			vendors[prefix] = vendor
+		}
+	}
+	if len(vendors) == 0 {
+		return fmt.Errorf("no vendors found in %v", source)
+	}
+
+	prefixes := make([]string, 0, len(vendors))
+	for prefix := range vendors {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, prefix := range prefixes {
+		fmt.Fprintf(w, "%v\t%v\n", prefix, vendors[prefix])
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	log.Infof("Written %v vendors to %v", len(vendors), path)
+	return f.Close()
+}
+
+// parseOUI reads the IEEE CSV (Registry,Assignment,Organization Name,...), the IEEE text format
+// ("00-00-0C   (hex)		Cisco Systems, Inc") or the lines "prefix<TAB>vendor".
+func parseOUI(r io.Reader) (map[string]string, error) {
+	reader := bufio.NewReader(r)
+	vendors := map[string]string{}
+	first, err := reader.Peek(9)
+	if err == nil && string(first) == "Registry," {
+		records, err := csv.NewReader(reader).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records[1:] {
+			if len(record) >= 3 && ouiPrefix(record[1]) != "" {
+				vendors[ouiPrefix(record[1])] = strings.TrimSpace(record[2])
+			}
+		}
+		return vendors, nil
+	}
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		var prefix, vendor string
+		if i := strings.Index(line, "(hex)"); i > 0 {
+			prefix, vendor = line[:i], line[i+len("(hex)"):]
+		} else if arr := strings.SplitN(line, "\t", 2); len(arr) == 2 {
+			prefix, vendor = arr[0], arr[1]
+		}
+		prefix, vendor = ouiPrefix(prefix), strings.TrimSpace(vendor)
+		if prefix != "" && vendor != "" {
+			vendors[prefix] = vendor
+		}
+	}
+	return vendors, scanner.Err()
+}
+
+// ouiPrefix normalizes a prefix to upper hex digits, only the lengths of MA-L, MA-M and MA-S are valid.
+func ouiPrefix(value string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(value) {
+		switch {
+		case r >= '0' && r <= '9', r >= 'A' && r <= 'F':
+			b.WriteRune(r)
+		case r >= 'a' && r <= 'f':
+			b.WriteRune(r - 'a' + 'A')
+		case r == '-' || r == ':' || r == '.':
+		default:
+			return ""
+		}
+	}
+	if n := b.Len(); n != 6 && n != 7 && n != 9 {
+		return ""
+	}
+	return b.String()
+}
+
+func (o *OUI) vendor(mac string) string {
+	hex := strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.ToUpper(mac))
+	if len(hex) != 12 {
+		return ""
+	}
+	// Locally administered addresses (random MACs of phones, virtual interfaces) have no vendor
+	if strings.ContainsRune("2367ABEF", rune(hex[1])) {
+		return ""
+	}
+	for _, n := range []int{9, 7, 6} {
+		if vendor, ok := o.vendors[hex[:n]]; ok {
+			return vendor
+		}
+	}
+	return ""
+}
+
+// apply puts the vendor into the line and guesses the type of the device, if it is not set.
+func (o *OUI) apply(line *LineOfData) {
+	line.Vendor = o.vendor(line.Mac)
+	if line.Vendor == "" || line.TypeD != "" {
+		return
+	}
+	vendor := strings.ToLower(line.Vendor)
+	for _, t := range ouiTypes {
+		if strings.Contains(vendor, t.keyword) {
+			line.TypeD = t.typeD
+			return
+		}
+	}
+}
+
+func (o *OUI) mergeInto(ipToMac map[string]LineOfData) {
+	for ip, line := range ipToMac {
+		o.apply(&line)
+		ipToMac[ip] = line
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseOUI(t *testing.T) {
+	inputs := map[string]string{
+		"text": "OUI/MA-L\t\t\tOrganization\n00-80-77   (hex)\t\tBrother Industries, Ltd.\n008077     (base 16)\t\tBrother Industries, Ltd.\n",
+		"csv":  "Registry,Assignment,Organization Name,Organization Address\nMA-L,008077,\"Brother Industries, Ltd.\",Nagoya JP\nMA-S,70B3D5F2E,Example Ltd,\n",
+		"file": "008077\tBrother Industries, Ltd.\n",
+	}
+	for name, input := range inputs {
+		vendors, err := parseOUI(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if vendors["008077"] != "Brother Industries, Ltd." {
+			t.Errorf("%v: %v", name, vendors)
+		}
+	}
+	vendors, _ := parseOUI(strings.NewReader(inputs["csv"]))
+	if vendors["70B3D5F2E"] != "Example Ltd" {
+		t.Errorf("MA-S %v", vendors)
+	}
+}
+
+func TestOUIApply(t *testing.T) {
+	o := &OUI{vendors: map[string]string{
+		"008077":    "Brother Industries, Ltd.",
+		"00C0EE":    "Kyocera Display Corporation",
+		"0017C8":    "KYOCERA Document Solutions Inc.",
+		"70B3D5F2E": "Example Ltd",
+		"70B3D5":    "IEEE Registration Authority",
+	}}
+	cases := []struct{ mac, vendor, typeD string }{
+		{"00:80:77:01:02:03", "Brother Industries, Ltd.", "prn"},
+		{"00:C0:EE:01:02:03", "Kyocera Display Corporation", ""},
+		{"00-17-C8-01-02-03", "KYOCERA Document Solutions Inc.", "prn"},
+		{"70:B3:D5:F2:E0:01", "Example Ltd", ""},
+		{"02:80:77:01:02:03", "", ""},
+	}
+	for _, c := range cases {
+		line := LineOfData{}
+		line.Mac = c.mac
+		o.apply(&line)
+		if line.Vendor != c.vendor || line.TypeD != c.typeD {
+			t.Errorf("%v: vendor %q, type %q", c.mac, line.Vendor, line.TypeD)
+		}
+	}
+
+	line := LineOfData{}
+	line.Mac, line.TypeD = "00:80:77:01:02:03", "pc"
+	if o.apply(&line); line.TypeD != "pc" {
+		t.Errorf("the type of the comment is replaced by %v", line.TypeD)
+	}
+}
+
+func TestOUIRegistry(t *testing.T) {
+	if vendor := newOUI("").vendor("00:0C:42:01:02:03"); vendor != "Routerboard.com" {
+		t.Errorf("bundled registry %q", vendor)
+	}
+
+	path := filepath.Join(t.TempDir(), "oui.txt")
+	if err := ioutil.WriteFile(path, []byte("000C42\tMikrotik SIA\n70B3D5F2E\tExample Ltd\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	o := newOUI(path)
+	for mac, want := range map[string]string{"00:0C:42:01:02:03": "Mikrotik SIA", "70:B3:D5:F2:E0:01": "Example Ltd", "B8:27:EB:01:02:03": "Raspberry Pi Foundation"} {
+		if vendor := o.vendor(mac); vendor != want {
+			t.Errorf("%v: %q instead of %q", mac, vendor, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/go-routeros/routeros/proto"
+	log "github.com/sirupsen/logrus"
+)
+
+type pppSession struct {
+	Name,
+	Address,
+	CallerID,
+	Service,
+	Router string
+}
+
+type pppSecret struct {
+	Name,
+	Service,
+	Profile,
+	Comment string
+}
+
+type PPP struct {
+	active  map[string]pppSession
+	secrets map[string]pppSecret
+	sync.RWMutex
+}
+
+func newPPPSession(r *router, re *proto.Sentence) pppSession {
+	return pppSession{
+		Name:     re.Map["name"],
+		Address:  re.Map["address"],
+		CallerID: re.Map["caller-id"],
+		Service:  re.Map["service"],
+		Router:   r.name,
+	}
+}
+
+// getPPPFromMT gets the active PPP sessions and the PPP secrets from the routers.
+func (data *Transport) getPPPFromMT() {
+	active := map[string]pppSession{}
+	secrets := map[string]pppSecret{}
+	failed := data.pollRouters("PPP sessions", func(r *router) error {
+		reply, err := r.client.Run("/ppp/active/print")
+		if err != nil {
+			return err
+		}
+		reply2, err := r.client.Run("/ppp/secret/print")
+		if err != nil {
+			return err
+		}
+		for _, re := range reply.Re {
+			session := newPPPSession(r, re)
+			if _, ok := active[session.Address]; ok || session.Address == "" {
+				continue
+			}
+			active[session.Address] = session
+		}
+		for _, re := range reply2.Re {
+			secret := pppSecret{
+				Name:    re.Map["name"],
+				Service: re.Map["service"],
+				Profile: re.Map["profile"],
+				Comment: re.Map["comment"],
+			}
+			secrets[pppSecretKey(r.name, secret.Name)] = secret
+		}
+		return nil
+	})
+
+	data.ppp.Lock()
+	for ip, session := range data.ppp.active {
+		if _, ok := active[ip]; !ok && failed[session.Router] {
+			active[ip] = session
+		}
+	}
+	for key, secret := range data.ppp.secrets {
+		if failed[strings.SplitN(key, "/", 2)[0]] {
+			secrets[key] = secret
+		}
+	}
+	data.ppp.active = active
+	data.ppp.secrets = secrets
+	data.ppp.Unlock()
+	log.Tracef("Get %v active PPP sessions from mikrotik", len(active))
+}
+
+// The secrets of every router are separate
+func pppSecretKey(router, name string) string {
+	return router + "/" + name
+}
+
+// getPPPSessionFromMT asks the routers about a single tunnel address, which appeared after the last poll.
+func (data *Transport) getPPPSessionFromMT(ctx context.Context, ip string) (pppSession, bool) {
+	for _, r := range data.routersFor(ip) {
+		reply, err := r.client.RunContext(ctx, "/ppp/active/print", "?address="+ip)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			if err != errRouterDown {
+				log.Errorf("Error getting the PPP session of %v from %v:%v", ip, r.name, err)
+			}
+			continue
+		}
+		if len(reply.Re) == 0 {
+			continue
+		}
+		session := newPPPSession(r, reply.Re[0])
+
+		data.ppp.Lock()
+		if data.ppp.active == nil {
+			data.ppp.active = map[string]pppSession{}
+		}
+		data.ppp.active[ip] = session
+		data.ppp.Unlock()
+		return session, true
+	}
+	return pppSession{}, false
+}
+
+func (session pppSession) applyToDevice(device *DeviceType) {
+	device.Service = session.Service
+	if device.Router == "" {
+		device.Router = session.Router
+	}
+	device.CallerID = session.CallerID
+	// PPPoE passes the MAC address of the client in caller-id, the tunnels pass the remote address
+	if _, err := net.ParseMAC(session.CallerID); err == nil {
+		device.Mac = session.CallerID
+	}
+}
+
+// apply puts the name of the PPP secret as the login and its comment into the line.
+func (p *PPP) apply(line *LineOfData, quota QuotaType) bool {
+	p.RLock()
+	defer p.RUnlock()
+	session, ok := p.active[line.IP]
+	if !ok {
+		return false
+	}
+	session.applyToDevice(&line.DeviceType)
+	line.Login = session.Name
+	if secret, ok := p.secrets[pppSecretKey(session.Router, session.Name)]; ok && secret.Comment != "" {
+		line.applyComment(secret.Comment, quota)
+	}
+	return true
+}
+
+func (p *PPP) mergeInto(ipToMac map[string]LineOfData, quota QuotaType) {
+	p.RLock()
+	ips := make([]string, 0, len(p.active))
+	for ip := range p.active {
+		ips = append(ips, ip)
+	}
+	p.RUnlock()
+
+	mergeIPs(ipToMac, ips, quota, func(line *LineOfData) bool {
+		return p.apply(line, quota)
+	})
+}
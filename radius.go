@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RADIUS accounting (RFC 2866)
+
+const (
+	radiusAccountingRequest  = 4
+	radiusAccountingResponse = 5
+
+	radiusUserName         = 1
+	radiusNASIPAddress     = 4
+	radiusFramedIPAddress  = 8
+	radiusCallingStationID = 31
+	radiusAcctStatusType   = 40
+	radiusAcctSessionID    = 44
+
+	radiusStatusStart   = 1
+	radiusStatusStop    = 2
+	radiusStatusInterim = 3
+	radiusStatusOn      = 7
+	radiusStatusOff     = 8
+)
+
+type radiusPacket struct {
+	Code          uint8
+	Identifier    uint8
+	Authenticator [16]byte
+	attributes    map[uint8][]byte
+}
+
+type radiusSession struct {
+	UserName,
+	IP,
+	Mac,
+	SessionID,
+	NAS string
+	Start      time.Time
+	End        time.Time
+	LastUpdate time.Time
+}
+
+type Radius struct {
+	active  map[string]radiusSession
+	history map[string][]radiusSession
+	secrets map[string]string
+	sync.RWMutex
+}
+
+func parseRadiusClients(clients []string) map[string]string {
+	secrets := map[string]string{}
+	for _, client := range clients {
+		if client == "" {
+			continue
+		}
+		arr := strings.SplitN(client, "=", 2)
+		if len(arr) != 2 {
+			log.Errorf("Error parse RADIUS client(%v), the format is ip=secret", client)
+			continue
+		}
+		secrets[strings.TrimSpace(arr[0])] = arr[1]
+	}
+	return secrets
+}
+
+func parseRadiusPacket(buf []byte) (*radiusPacket, error) {
+	if len(buf) < 20 {
+		return nil, errors.New("packet is too short")
+	}
+	length := int(binary.BigEndian.Uint16(buf[2:4]))
+	if length < 20 || length > len(buf) {
+		return nil, fmt.Errorf("wrong length of packet:%v", length)
+	}
+	packet := &radiusPacket{
+		Code:       buf[0],
+		Identifier: buf[1],
+		attributes: map[uint8][]byte{},
+	}
+	copy(packet.Authenticator[:], buf[4:20])
+	for attrs := buf[20:length]; len(attrs) > 0; {
+		if len(attrs) < 2 || attrs[1] < 2 || int(attrs[1]) > len(attrs) {
+			return nil, errors.New("wrong attribute")
+		}
+		packet.attributes[attrs[0]] = attrs[2:attrs[1]]
+		attrs = attrs[attrs[1]:]
+	}
+	return packet, nil
+}
+
+// checkRadiusAuthenticator checks the Request Authenticator of an Accounting-Request:
+// MD5(Code+Identifier+Length+16 zero octets+Attributes+Secret).
+func checkRadiusAuthenticator(buf []byte, secret string) bool {
+	length := binary.BigEndian.Uint16(buf[2:4])
+	hash := md5.New()
+	hash.Write(buf[:4])
+	hash.Write(make([]byte, 16))
+	hash.Write(buf[20:length])
+	hash.Write([]byte(secret))
+	return bytes.Equal(hash.Sum(nil), buf[4:20])
+}
+
+func radiusResponse(request *radiusPacket, secret string) []byte {
+	response := []byte{radiusAccountingResponse, request.Identifier, 0, 20}
+	hash := md5.New()
+	hash.Write(response)
+	hash.Write(request.Authenticator[:])
+	hash.Write([]byte(secret))
+	return append(response, hash.Sum(nil)...)
+}
+
+func (data *Transport) listenRadius() {
+	data.radius.Lock()
+	data.radius.secrets = parseRadiusClients(cfg.RadiusClients)
+	data.radius.Unlock()
+
+	addr, err := net.ResolveUDPAddr("udp", cfg.RadiusAddr)
+	if err != nil {
+		log.Errorf("Error resolve RADIUS address(%v):%v", cfg.RadiusAddr, err)
+		return
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		log.Errorf("Error listen RADIUS accounting on %v:%v", cfg.RadiusAddr, err)
+		return
+	}
+	log.Infof("Start listening to RADIUS accounting on %v", cfg.RadiusAddr)
+
+	for {
+		buf := make([]byte, 4096)
+		rlen, remote, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Errorf("Error: %v\n", err)
+			continue
+		}
+		data.handleRadiusPacket(conn, buf[:rlen], remote)
+	}
+}
+
+func (data *Transport) handleRadiusPacket(conn *net.UDPConn, buf []byte, remote *net.UDPAddr) {
+	data.radius.RLock()
+	secret, ok := data.radius.secrets[remote.IP.String()]
+	data.radius.RUnlock()
+	if !ok {
+		log.Warningf("RADIUS packet from unknown client:%v", remote.IP)
+		return
+	}
+	packet, err := parseRadiusPacket(buf)
+	if err != nil {
+		log.Errorf("Error parse RADIUS packet from %v:%v", remote.IP, err)
+		return
+	}
+	if packet.Code != radiusAccountingRequest {
+		log.Debugf("RADIUS packet with code %v from %v is ignored", packet.Code, remote.IP)
+		return
+	}
+	if !checkRadiusAuthenticator(buf, secret) {
+		log.Warningf("RADIUS packet from %v has wrong authenticator, check the shared secret", remote.IP)
+		return
+	}
+
+	nas := remote.IP.String()
+	if value, ok := packet.attributes[radiusNASIPAddress]; ok && len(value) == 4 {
+		nas = net.IP(value).String()
+	}
+	var status uint32
+	if value, ok := packet.attributes[radiusAcctStatusType]; ok && len(value) == 4 {
+		status = binary.BigEndian.Uint32(value)
+	}
+	session := radiusSession{
+		UserName:  string(packet.attributes[radiusUserName]),
+		SessionID: string(packet.attributes[radiusAcctSessionID]),
+		NAS:       nas,
+	}
+	if value, ok := packet.attributes[radiusFramedIPAddress]; ok && len(value) == 4 {
+		session.IP = net.IP(value).String()
+	}
+	if mac, err := net.ParseMAC(string(packet.attributes[radiusCallingStationID])); err == nil {
+		session.Mac = strings.ToUpper(mac.String())
+	}
+
+	now := time.Now().In(data.Location)
+	switch status {
+	case radiusStatusStart, radiusStatusInterim:
+		data.radius.start(session, now)
+	case radiusStatusStop:
+		data.radius.stop(session, now)
+	case radiusStatusOn, radiusStatusOff:
+		data.radius.stopNAS(nas, now)
+	}
+	log.Debugf("RADIUS accounting from %v: status:%v user:%v ip:%v mac:%v", remote.IP, status, session.UserName, session.IP, session.Mac)
+
+	if _, err := conn.WriteToUDP(radiusResponse(packet, secret), remote); err != nil {
+		log.Errorf("Error send RADIUS response to %v:%v", remote.IP, err)
+	}
+}
+
+func (r *Radius) start(session radiusSession, now time.Time) {
+	if session.IP == "" || session.UserName == "" {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.active == nil {
+		r.active = map[string]radiusSession{}
+	}
+	session.Start = now
+	if old, ok := r.active[session.IP]; ok {
+		if old.UserName == session.UserName {
+			session.Start = old.Start
+		} else {
+			r.close(old, now)
+		}
+	}
+	session.LastUpdate = now
+	r.active[session.IP] = session
+}
+
+func (r *Radius) stop(session radiusSession, now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	if old, ok := r.active[session.IP]; ok && (session.SessionID == "" || old.SessionID == session.SessionID) {
+		delete(r.active, session.IP)
+		r.close(old, now)
+	}
+}
+
+// stopNAS closes all sessions of a NAS, which has sent Accounting-On or Accounting-Off.
+func (r *Radius) stopNAS(nas string, now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	for ip, session := range r.active {
+		if session.NAS == nas {
+			delete(r.active, ip)
+			r.close(session, now)
+		}
+	}
+}
+
+func (r *Radius) close(session radiusSession, now time.Time) {
+	if r.history == nil {
+		r.history = map[string][]radiusSession{}
+	}
+	session.End = now
+	r.history[session.IP] = append(r.history[session.IP], session)
+}
+
+// expire closes the sessions without updates and removes the old history.
+func (r *Radius) expire(now time.Time, timeout, retention time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	for ip, session := range r.active {
+		if now.Sub(session.LastUpdate) > timeout {
+			delete(r.active, ip)
+			r.close(session, session.LastUpdate)
+		}
+	}
+	for ip, sessions := range r.history {
+		kept := sessions[:0]
+		for _, session := range sessions {
+			if now.Sub(session.End) < retention {
+				kept = append(kept, session)
+			}
+		}
+		if len(kept) == 0 {
+			delete(r.history, ip)
+		} else {
+			r.history[ip] = kept
+		}
+	}
+}
+
+func (data *Transport) loopExpireRadius() {
+	for {
+		time.Sleep(time.Minute)
+		timeout, err := time.ParseDuration(cfg.RadiusSessionTimeout)
+		if err != nil {
+			timeout = 24 * time.Hour
+		}
+		retention, err := time.ParseDuration(cfg.RadiusHistory)
+		if err != nil {
+			retention = 7 * 24 * time.Hour
+		}
+		data.radius.expire(time.Now(), timeout, retention)
+	}
+}
+
+// find returns the session that owned the IP at the given time.
+func (r *Radius) find(ip string, at time.Time) (radiusSession, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	if session, ok := r.active[ip]; ok && !at.Before(session.Start) {
+		return session, true
+	}
+	for _, session := range r.history[ip] {
+		if !at.Before(session.Start) && !at.After(session.End) {
+			return session, true
+		}
+	}
+	return radiusSession{}, false
+}
+
+// apply puts the user name of the active session into the line. It is applied last,
+// because RADIUS is the most reliable source of the user.
+func (r *Radius) apply(line *LineOfData) bool {
+	r.RLock()
+	defer r.RUnlock()
+	session, ok := r.active[line.IP]
+	if !ok {
+		return false
+	}
+	if session.Mac != "" && (line.Mac == "" || line.Mac == line.IP) {
+		line.Mac = session.Mac
+	}
+	line.Login = session.UserName
+	return true
+}
+
+func (r *Radius) mergeInto(ipToMac map[string]LineOfData, quota QuotaType) {
+	r.RLock()
+	ips := make([]string, 0, len(r.active))
+	for ip := range r.active {
+		ips = append(ips, ip)
+	}
+	r.RUnlock()
+
+	mergeIPs(ipToMac, ips, quota, func(line *LineOfData) bool {
+		return r.apply(line)
+	})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+type radiusAttribute struct {
+	typ   uint8
+	value []byte
+}
+
+// accountingRequest builds an Accounting-Request signed with the secret as a NAS does.
+func accountingRequest(identifier uint8, secret string, attributes ...radiusAttribute) []byte {
+	buf := []byte{radiusAccountingRequest, identifier, 0, 0}
+	buf = append(buf, make([]byte, 16)...)
+	for _, attr := range attributes {
+		buf = append(buf, attr.typ, uint8(len(attr.value)+2))
+		buf = append(buf, attr.value...)
+	}
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)))
+	hash := md5.New()
+	hash.Write(buf)
+	hash.Write([]byte(secret))
+	copy(buf[4:20], hash.Sum(nil))
+	return buf
+}
+
+func statusType(status uint32) radiusAttribute {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, status)
+	return radiusAttribute{radiusAcctStatusType, value}
+}
+
+func TestParseRadiusPacket(t *testing.T) {
+	buf := accountingRequest(7, "secret",
+		radiusAttribute{radiusUserName, []byte("alice")},
+		radiusAttribute{radiusFramedIPAddress, []byte{10, 0, 0, 5}},
+		statusType(radiusStatusStart))
+	packet, err := parseRadiusPacket(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.Code != radiusAccountingRequest || packet.Identifier != 7 {
+		t.Errorf("code %v, identifier %v", packet.Code, packet.Identifier)
+	}
+	if string(packet.attributes[radiusUserName]) != "alice" || !bytes.Equal(packet.attributes[radiusFramedIPAddress], []byte{10, 0, 0, 5}) {
+		t.Errorf("attributes %v", packet.attributes)
+	}
+
+	// The bytes after Length are the padding of UDP and are not attributes
+	if _, err := parseRadiusPacket(append(buf, 0, 0, 0)); err != nil {
+		t.Errorf("padding: %v", err)
+	}
+
+	broken := map[string][]byte{
+		"short":               buf[:19],
+		"length over packet":  append([]byte{4, 1, 0, 200}, buf[4:]...),
+		"length under header": append([]byte{4, 1, 0, 10}, buf[4:]...),
+		"attribute length 1":  append(append([]byte{}, buf[:len(buf)-6]...), radiusAcctStatusType, 1, 0, 0, 0, 1),
+		"attribute over end":  accountingRequestWithTail(buf, radiusUserName, 10, 'a'),
+	}
+	for name, b := range broken {
+		if _, err := parseRadiusPacket(b); err == nil {
+			t.Errorf("%v: no error", name)
+		}
+	}
+}
+
+// accountingRequestWithTail appends a raw attribute and fixes Length, so only the attribute is wrong.
+func accountingRequestWithTail(buf []byte, tail ...byte) []byte {
+	b := append(append([]byte{}, buf...), tail...)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b
+}
+
+func TestCheckRadiusAuthenticator(t *testing.T) {
+	buf := accountingRequest(1, "secret", radiusAttribute{radiusUserName, []byte("alice")})
+	if !checkRadiusAuthenticator(buf, "secret") {
+		t.Error("the right secret is rejected")
+	}
+	if checkRadiusAuthenticator(buf, "other") {
+		t.Error("a wrong secret is accepted")
+	}
+	buf[len(buf)-1] = 'x'
+	if checkRadiusAuthenticator(buf, "secret") {
+		t.Error("a changed attribute is accepted")
+	}
+}
+
+func TestHandleRadiusPacket(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer server.Close()
+	client, err := net.DialUDP("udp", nil, server.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	remote := client.LocalAddr().(*net.UDPAddr)
+
+	data := &Transport{Location: time.UTC}
+	data.radius.secrets = map[string]string{"127.0.0.1": "secret"}
+
+	send := func(buf []byte) []byte {
+		data.handleRadiusPacket(server, buf, remote)
+		client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		answer := make([]byte, 64)
+		n, err := client.Read(answer)
+		if err != nil {
+			return nil
+		}
+		return answer[:n]
+	}
+
+	start := accountingRequest(42, "secret",
+		radiusAttribute{radiusUserName, []byte("alice")},
+		radiusAttribute{radiusFramedIPAddress, []byte{10, 0, 0, 5}},
+		radiusAttribute{radiusCallingStationID, []byte("aa:bb:cc:dd:ee:ff")},
+		radiusAttribute{radiusAcctSessionID, []byte("s1")},
+		statusType(radiusStatusStart))
+	answer := send(start)
+	if len(answer) != 20 || answer[0] != radiusAccountingResponse || answer[1] != 42 {
+		t.Fatalf("answer %v", answer)
+	}
+	// Response Authenticator: MD5(Code+Identifier+Length+Request Authenticator+Secret)
+	hash := md5.New()
+	hash.Write(answer[:4])
+	hash.Write(start[4:20])
+	hash.Write([]byte("secret"))
+	if !bytes.Equal(answer[4:20], hash.Sum(nil)) {
+		t.Error("wrong Response Authenticator")
+	}
+	session, ok := data.radius.find("10.0.0.5", time.Now())
+	if !ok || session.UserName != "alice" || session.Mac != "AA:BB:CC:DD:EE:FF" || session.NAS != "127.0.0.1" {
+		t.Fatalf("session %+v, %v", session, ok)
+	}
+
+	// A packet signed with another secret is dropped without an answer
+	forged := accountingRequest(43, "other",
+		radiusAttribute{radiusUserName, []byte("mallory")},
+		radiusAttribute{radiusFramedIPAddress, []byte{10, 0, 0, 6}},
+		statusType(radiusStatusStart))
+	if answer := send(forged); answer != nil {
+		t.Errorf("answer to a forged packet %v", answer)
+	}
+	if _, ok := data.radius.find("10.0.0.6", time.Now()); ok {
+		t.Error("the forged session is started")
+	}
+
+	stop := accountingRequest(44, "secret",
+		radiusAttribute{radiusUserName, []byte("alice")},
+		radiusAttribute{radiusFramedIPAddress, []byte{10, 0, 0, 5}},
+		radiusAttribute{radiusAcctSessionID, []byte("s1")},
+		statusType(radiusStatusStop))
+	if answer := send(stop); answer == nil {
+		t.Fatal("no answer to Stop")
+	}
+	data.radius.RLock()
+	_, active := data.radius.active["10.0.0.5"]
+	history := len(data.radius.history["10.0.0.5"])
+	data.radius.RUnlock()
+	if active || history != 1 {
+		t.Errorf("after Stop active %v, history %v", active, history)
+	}
+}
+
+func TestRadiusAccountingOff(t *testing.T) {
+	r := &Radius{}
+	now := time.Now()
+	r.start(radiusSession{UserName: "alice", IP: "10.0.0.5", NAS: "10.0.0.1"}, now)
+	r.start(radiusSession{UserName: "bob", IP: "10.0.1.5", NAS: "10.0.1.1"}, now)
+	r.stopNAS("10.0.0.1", now.Add(time.Minute))
+	if _, ok := r.find("10.0.0.5", now.Add(2*time.Minute)); ok {
+		t.Error("the session of the NAS is not closed")
+	}
+	if _, ok := r.find("10.0.0.5", now.Add(30*time.Second)); !ok {
+		t.Error("the closed session is not kept in the history")
+	}
+	if _, ok := r.find("10.0.1.5", now.Add(2*time.Minute)); !ok {
+		t.Error("the session of another NAS is closed")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reverse DNS names of the remote addresses. The lookups never block the decoding of flows:
+// an address without a name in the cache is queued to the workers and written as is this time.
+
+func (c *Cache) startReverseDNS(cfg *Config) {
+	c.Lock()
+	c.queue = make(chan string, 1024)
+	c.Unlock()
+
+	resolver := &net.Resolver{}
+	if cfg.ReverseDNSResolver != "" {
+		address := hostWithPort(cfg.ReverseDNSResolver, "53")
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, address)
+			},
+		}
+	}
+	workers := cfg.ReverseDNSWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go c.resolveWorker(resolver)
+	}
+	go c.loopExpire()
+}
+
+// hostname returns the name of the IP address from the cache or the IP address itself.
+func (c *Cache) hostname(ip string) string {
+	c.RLock()
+	record, ok := c.cache[ip]
+	queue := c.queue
+	c.RUnlock()
+	if queue == nil {
+		return ip
+	}
+	if ok && time.Now().Before(record.timeout) {
+		if record.Hostname != "" {
+			return record.Hostname
+		}
+		return ip
+	}
+
+	// The pending record prevents the address from being queued again until it is resolved
+	c.Lock()
+	c.cache[ip] = cacheRecord{timeout: time.Now().Add(time.Minute)}
+	c.Unlock()
+	select {
+	case queue <- ip:
+	default:
+		log.Tracef("The queue of reverse DNS lookups is full, %v is skipped", ip)
+	}
+	return ip
+}
+
+func (c *Cache) resolveWorker(resolver *net.Resolver) {
+	timeout := parseDurationOr(cfg.ReverseDNSTimeout, 2*time.Second)
+	ttl := parseDurationOr(cfg.ReverseDNSTTL, time.Hour)
+	negativeTTL := parseDurationOr(cfg.ReverseDNSNegativeTTL, 10*time.Minute)
+	for ip := range c.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		names, err := resolver.LookupAddr(ctx, ip)
+		cancel()
+		record := cacheRecord{timeout: time.Now().Add(negativeTTL)}
+		if err == nil && len(names) > 0 {
+			record = cacheRecord{Hostname: strings.TrimSuffix(names[0], "."), timeout: time.Now().Add(ttl)}
+		} else if err != nil {
+			log.Tracef("Error reverse lookup of %v:%v", ip, err)
+		}
+		c.Lock()
+		c.cache[ip] = record
+		c.Unlock()
+	}
+}
+
+func (c *Cache) loopExpire() {
+	for {
+		time.Sleep(10 * time.Minute)
+		now := time.Now()
+		c.Lock()
+		for ip, record := range c.cache {
+			if now.After(record.timeout) {
+				delete(c.cache, ip)
+			}
+		}
+		c.Unlock()
+	}
+}
+
+func parseDurationOr(value string, defaultValue time.Duration) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+	return duration
+}
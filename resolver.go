@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// resolver is a source of the device by its IP address: the MAC address, the login, the comment with the quotas.
+type resolver interface {
+	// resolve fills the line and reports whether the source knows the device. The commands to the routers
+	// are run with the context, which is done when the source is too late.
+	resolve(ctx context.Context, line *LineOfData, at time.Time) bool
+}
+
+type resolverFunc func(ctx context.Context, line *LineOfData, at time.Time) bool
+
+func (f resolverFunc) resolve(ctx context.Context, line *LineOfData, at time.Time) bool {
+	return f(ctx, line, at)
+}
+
+type chainEntry struct {
+	name    string
+	timeout time.Duration
+	// The sessions depend on the time of the flow, so they are asked on every request,
+	// the other sources only when the device is looked up
+	session bool
+	// The source reads only the tables in memory, it is asked without a goroutine and the timeout
+	local bool
+	resolver
+}
+
+// resolverChain are the sources asked one after another. All of them are asked, a later source overrides
+// the data of the earlier ones, e.g. the login of RADIUS overrides the login of PPP.
+type resolverChain []chainEntry
+
+// newResolverChain makes the chain from the list in the format name[:timeout], e.g. dhcp:3s.
+func (data *Transport) newResolverChain(list []string, defaultTimeout string) resolverChain {
+	sources := data.resolverSources()
+	chain := resolverChain{}
+	for _, value := range list {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		arr := strings.SplitN(value, ":", 2)
+		entry, ok := sources[arr[0]]
+		if !ok {
+			log.Errorf("Unknown source of devices(%v), the sources are dhcp, arp, ppp, bridge, neighbors, snmp, wireguard, hotspot, radius, static, rdns", arr[0])
+			continue
+		}
+		entry.name = arr[0]
+		entry.timeout = parseDurationOr(defaultTimeout, 2*time.Second)
+		if len(arr) == 2 {
+			entry.timeout = parseDurationOr(arr[1], entry.timeout)
+		}
+		chain = append(chain, entry)
+	}
+	return chain
+}
+
+func (data *Transport) resolverSources() map[string]chainEntry {
+	return map[string]chainEntry{
+		"dhcp": {resolver: resolverFunc(func(ctx context.Context, line *LineOfData, _ time.Time) bool {
+			return data.resolveFromDHCP(ctx, &line.DeviceType)
+		})},
+		"arp": {resolver: resolverFunc(func(ctx context.Context, line *LineOfData, _ time.Time) bool {
+			return data.resolveFromARP(ctx, &line.DeviceType)
+		})},
+		"ppp": {resolver: resolverFunc(func(ctx context.Context, line *LineOfData, _ time.Time) bool {
+			if line.Mac == "" && cfg.UsePPP && data.connectedToMT() {
+				// The session is kept in the table of active sessions
+				data.getPPPSessionFromMT(ctx, line.IP)
+			}
+			return data.ppp.apply(line, data.defaultQuota())
+		})},
+		"bridge": {resolver: resolverFunc(func(ctx context.Context, line *LineOfData, _ time.Time) bool {
+			if line.Mac != "" || !cfg.UseBridgeHost || !data.connectedToMT() {
+				return false
+			}
+			return data.resolveFromBridge(ctx, &line.DeviceType)
+		})},
+		"neighbors": {local: true, resolver: resolverFunc(func(_ context.Context, line *LineOfData, _ time.Time) bool {
+			return data.localNeighbors.apply(line)
+		})},
+		"snmp": {local: true, resolver: resolverFunc(func(_ context.Context, line *LineOfData, _ time.Time) bool {
+			return data.snmp.apply(line)
+		})},
+		"wireguard": {local: true, resolver: resolverFunc(func(_ context.Context, line *LineOfData, _ time.Time) bool {
+			return data.wireGuard.apply(line, data.defaultQuota())
+		})},
+		"hotspot": {session: true, local: true, resolver: resolverFunc(func(_ context.Context, line *LineOfData, at time.Time) bool {
+			ok := data.hotSpot.apply(line, data.defaultQuota())
+			if session, found := data.hotSpot.find(line.IP, at); found {
+				line.Login = session.Login
+				ok = true
+			}
+			return ok
+		})},
+		"radius": {session: true, local: true, resolver: resolverFunc(func(_ context.Context, line *LineOfData, at time.Time) bool {
+			ok := data.radius.apply(line)
+			if session, found := data.radius.find(line.IP, at); found {
+				line.Login = session.UserName
+				ok = true
+			}
+			return ok
+		})},
+		"static": {local: true, resolver: resolverFunc(func(_ context.Context, line *LineOfData, _ time.Time) bool {
+			return data.static.apply(line, data.defaultQuota())
+		})},
+		"rdns": {local: true, resolver: resolverFunc(func(_ context.Context, line *LineOfData, _ time.Time) bool {
+			if !cfg.UseReverseDNS || line.HostName != "" {
+				return false
+			}
+			if name := cache.hostname(line.IP); name != line.IP {
+				line.HostName = name
+				return true
+			}
+			return false
+		})},
+	}
+}
+
+// resolve asks the sources, sessionsOnly - only the sessions for the device already in the table.
+// Every source is asked, also after one has answered: the last answer wins the fields it sets,
+// and the source which answered last is put into the line.
+func (chain resolverChain) resolve(line *LineOfData, at time.Time, sessionsOnly bool) {
+	for _, entry := range chain {
+		if sessionsOnly && !entry.session {
+			continue
+		}
+		start := time.Now()
+		if entry.resolveWithTimeout(line, at) {
+			line.Source = entry.name
+			log.Tracef("Source %v answered for %v in %v: MAC:%v, login:%v", entry.name, line.IP, time.Since(start), line.Mac, line.Login)
+		} else {
+			log.Tracef("Source %v does not know %v (%v)", entry.name, line.IP, time.Since(start))
+		}
+	}
+}
+
+// resolveWithTimeout works on a copy of the line, the answer of a source which is too late is dropped
+// and its commands to the routers are cancelled. The sources in memory answer at once and are asked directly.
+func (entry chainEntry) resolveWithTimeout(line *LineOfData, at time.Time) bool {
+	if entry.local {
+		return entry.resolve(context.Background(), line, at)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), entry.timeout)
+	defer cancel()
+	type answer struct {
+		line LineOfData
+		ok   bool
+	}
+	result := make(chan answer, 1)
+	go func(line LineOfData) {
+		ok := entry.resolve(ctx, &line, at)
+		result <- answer{line, ok}
+	}(*line)
+	select {
+	case a := <-result:
+		if a.ok {
+			*line = a.line
+		}
+		return a.ok
+	case <-ctx.Done():
+		log.Debugf("Source %v did not answer for %v in %v", entry.name, line.IP, entry.timeout)
+		return false
+	}
+}
+
+func (data *Transport) defaultQuota() QuotaType {
+	data.RLock()
+	defer data.RUnlock()
+	return data.QuotaType
+}
+
+func (data *Transport) resolveFromDHCP(ctx context.Context, device *DeviceType) bool {
+	if isIPv6(device.IP) {
+		if !cfg.UseIPv6 || !data.connectedToMT() {
+			return false
+		}
+		data.getInfoFromMTAboutIPv6(ctx, device)
+		return device.Mac != ""
+	}
+	for _, r := range data.routersFor(device.IP) {
+		reply, err := r.client.RunContext(ctx, "/ip/dhcp-server/lease/print", "?active-address="+device.IP)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			// A router which is not available fails at once, the supervisor logs it
+			if err != errRouterDown {
+				log.Errorf("Error getting the lease of %v from %v:%v", device.IP, r.name, err)
+			}
+			continue
+		}
+		for _, re := range reply.Re {
+			device.Id = re.Map[".id"]
+			device.Mac = re.Map["mac-address"]
+			device.HostName = re.Map["host-name"]
+			device.ClientID = re.Map["client-id"]
+			device.Groups = re.Map["address-lists"]
+			device.Router = r.name
+		}
+		if len(reply.Re) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (data *Transport) resolveFromARP(ctx context.Context, device *DeviceType) bool {
+	if device.Mac != "" || isIPv6(device.IP) {
+		return false
+	}
+	for _, r := range data.routersFor(device.IP) {
+		reply, err := r.client.RunContext(ctx, "/ip/arp/print", "?address="+device.IP)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			// A router which is not available fails at once, the supervisor logs it
+			if err != errRouterDown {
+				log.Errorf("Error getting the ARP entry of %v from %v:%v", device.IP, r.name, err)
+			}
+			continue
+		}
+		for _, re := range reply.Re {
+			device.Mac = re.Map["mac-address"]
+			device.Router = r.name
+		}
+		if device.Mac != "" {
+			return true
+		}
+	}
+	return false
+}
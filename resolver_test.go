@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResolverChainLastAnswerWins(t *testing.T) {
+	login := func(value string) resolverFunc {
+		return func(_ context.Context, line *LineOfData, _ time.Time) bool {
+			line.Login = value
+			return true
+		}
+	}
+	chain := resolverChain{
+		{name: "first", local: true, resolver: login("alice")},
+		{name: "second", local: true, resolver: login("bob")},
+		{name: "unknown", local: true, resolver: resolverFunc(func(context.Context, *LineOfData, time.Time) bool { return false })},
+	}
+	line := LineOfData{}
+	chain.resolve(&line, time.Now(), false)
+	if line.Login != "bob" || line.Source != "second" {
+		t.Errorf("login %v, source %v", line.Login, line.Source)
+	}
+}
+
+func TestResolveWithTimeout(t *testing.T) {
+	// A source in memory is asked directly, without the timeout
+	local := chainEntry{name: "static", local: true, resolver: resolverFunc(func(_ context.Context, line *LineOfData, _ time.Time) bool {
+		line.Mac = "AA:BB:CC:DD:EE:FF"
+		return true
+	})}
+	line := LineOfData{}
+	if !local.resolveWithTimeout(&line, time.Now()) || line.Mac != "AA:BB:CC:DD:EE:FF" {
+		t.Errorf("local source: %+v", line)
+	}
+
+	// The context of a late source is cancelled, its answer is dropped
+	cancelled := make(chan bool, 1)
+	slow := chainEntry{name: "dhcp", timeout: 20 * time.Millisecond, resolver: resolverFunc(func(ctx context.Context, line *LineOfData, _ time.Time) bool {
+		select {
+		case <-ctx.Done():
+			cancelled <- true
+		case <-time.After(time.Second):
+			cancelled <- false
+		}
+		line.Mac = "late"
+		return true
+	})}
+	line = LineOfData{}
+	if slow.resolveWithTimeout(&line, time.Now()) || line.Mac != "" {
+		t.Errorf("late source: %+v", line)
+	}
+	if !<-cancelled {
+		t.Error("the context of the late source is not cancelled")
+	}
+}
+
+func TestAPIClientRunContext(t *testing.T) {
+	// The connection is busy with the command of another caller
+	c := &apiClient{lock: make(chan struct{}, 1)}
+	c.lock <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.RunContext(ctx, "/ip/arp/print"); err != context.DeadlineExceeded {
+		t.Errorf("error %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("waited %v", time.Since(start))
+	}
+	if connectionLost(ctx.Err()) {
+		t.Error("the deadline of the caller closes the connection")
+	}
+}
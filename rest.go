@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-routeros/routeros"
+	"github.com/go-routeros/routeros/proto"
+)
+
+// routerClient runs the commands of the RouterOS API, e.g. "/ip/arp/print", "?address=10.0.0.1".
+// RunContext gives up when the context is done, so the callers with a deadline do not wait behind a slow router.
+type routerClient interface {
+	Run(sentence ...string) (*routeros.Reply, error)
+	RunContext(ctx context.Context, sentence ...string) (*routeros.Reply, error)
+	Close()
+}
+
+// restClient runs the commands of the RouterOS API over the REST API of RouterOS v7 (/rest/...):
+// print is GET with the queries as parameters, set is PATCH of the item, the other commands are POST.
+type restClient struct {
+	baseURL,
+	user,
+	password string
+	client *http.Client
+}
+
+func dialREST(address, user, password string, useTLS, insecure bool, timeout time.Duration) (*restClient, error) {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	c := &restClient{
+		baseURL:  scheme + "://" + address + "/rest",
+		user:     user,
+		password: password,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure}},
+		},
+	}
+	// The binary API checks the credentials at login, the REST API on the first request
+	if _, err := c.Run("/system/identity/print"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *restClient) Run(sentence ...string) (*routeros.Reply, error) {
+	return c.RunContext(context.Background(), sentence...)
+}
+
+func (c *restClient) RunContext(ctx context.Context, sentence ...string) (*routeros.Reply, error) {
+	if len(sentence) == 0 || !strings.HasPrefix(sentence[0], "/") {
+		return nil, fmt.Errorf("rest: wrong command %v", sentence)
+	}
+	command := sentence[0]
+	attributes := map[string]string{}
+	queries := []string{}
+	for _, word := range sentence[1:] {
+		switch {
+		case strings.HasPrefix(word, "="):
+			arr := strings.SplitN(word[1:], "=", 2)
+			if len(arr) == 1 {
+				arr = append(arr, "")
+			}
+			attributes[arr[0]] = arr[1]
+		case strings.HasPrefix(word, "?") && strings.Contains(word, "=") && !strings.ContainsAny(word[1:2], "#<>-"):
+			queries = append(queries, word[1:])
+		default:
+			return nil, fmt.Errorf("rest: unsupported word %v in %v", word, command)
+		}
+	}
+
+	i := strings.LastIndex(command, "/")
+	path, verb := command[:i], command[i+1:]
+	switch verb {
+	case "print":
+		values := url.Values{}
+		for _, query := range queries {
+			arr := strings.SplitN(query, "=", 2)
+			values.Set(arr[0], arr[1])
+		}
+		if proplist, ok := attributes[".proplist"]; ok {
+			values.Set(".proplist", proplist)
+		}
+		u := c.baseURL + path
+		if len(values) > 0 {
+			u += "?" + values.Encode()
+		}
+		return c.do(ctx, http.MethodGet, u, nil, true)
+	case "set":
+		id := attributes["numbers"]
+		if id == "" {
+			id = attributes[".id"]
+		}
+		delete(attributes, "numbers")
+		delete(attributes, ".id")
+		if id == "" || len(queries) > 0 {
+			return nil, fmt.Errorf("rest: %v needs the id of the item", command)
+		}
+		return c.do(ctx, http.MethodPatch, c.baseURL+path+"/"+url.PathEscape(id), attributes, false)
+	default:
+		body := map[string]interface{}{}
+		for key, value := range attributes {
+			body[key] = value
+		}
+		if len(queries) > 0 {
+			body[".query"] = queries
+		}
+		return c.do(ctx, http.MethodPost, c.baseURL+command, body, false)
+	}
+}
+
+// do sends the request and converts the items of the answer into the sentences of the binary API:
+// !re for print, the attributes of !done for the other commands.
+func (c *restClient) do(ctx context.Context, method, u string, body interface{}, print bool) (*routeros.Reply, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.user, c.password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		answer := struct{ Message, Detail string }{}
+		if json.Unmarshal(b, &answer) != nil || answer.Message == "" {
+			answer.Message = resp.Status
+		}
+		return nil, fmt.Errorf("rest: %v %v: %v %v", method, u, answer.Message, answer.Detail)
+	}
+
+	var items []map[string]interface{}
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &items)
+	} else if len(trimmed) > 0 {
+		item := map[string]interface{}{}
+		err = json.Unmarshal(trimmed, &item)
+		items = append(items, item)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rest: %v %v: %v", method, u, err)
+	}
+
+	reply := &routeros.Reply{Done: &proto.Sentence{Word: "!done", Map: map[string]string{}}}
+	for _, item := range items {
+		sentence := &proto.Sentence{Word: "!re", Map: map[string]string{}}
+		for key, value := range item {
+			v, ok := value.(string)
+			if !ok {
+				v = fmt.Sprint(value)
+			}
+			sentence.Map[key] = v
+			sentence.List = append(sentence.List, proto.Pair{Key: key, Value: v})
+		}
+		if print {
+			reply.Re = append(reply.Re, sentence)
+		} else {
+			reply.Done.Map, reply.Done.List = sentence.Map, sentence.List
+		}
+	}
+	return reply, nil
+}
+
+func (c *restClient) Close() {
+	c.client.CloseIdleConnections()
+}
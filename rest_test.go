@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type restRequest struct {
+	method, path string
+	query        map[string]string
+	body         map[string]interface{}
+}
+
+// restServer is the REST API of RouterOS answering by the handler, the requests are recorded.
+func restServer(t *testing.T, handle func(w http.ResponseWriter, req restRequest)) (*restClient, *[]restRequest) {
+	requests := []restRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "api" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":401,"message":"Unauthorized"}`))
+			return
+		}
+		req := restRequest{method: r.Method, path: r.URL.Path, query: map[string]string{}}
+		for key := range r.URL.Query() {
+			req.query[key] = r.URL.Query().Get(key)
+		}
+		if b, _ := ioutil.ReadAll(r.Body); len(b) > 0 {
+			json.Unmarshal(b, &req.body)
+		}
+		if req.path == "/rest/system/identity" {
+			w.Write([]byte(`{"name":"gw"}`))
+			return
+		}
+		requests = append(requests, req)
+		handle(w, req)
+	}))
+	t.Cleanup(server.Close)
+	c, err := dialREST(strings.TrimPrefix(server.URL, "http://"), "api", "secret", false, false, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, &requests
+}
+
+func TestRESTPrint(t *testing.T) {
+	c, requests := restServer(t, func(w http.ResponseWriter, req restRequest) {
+		w.Write([]byte(`[{".id":"*1","address":"10.0.0.1","mac-address":"AA:BB:CC:DD:EE:FF","dynamic":true,"timeout":300}]`))
+	})
+	reply, err := c.Run("/ip/arp/print", "?address=10.0.0.1", "=.proplist=.id,address,mac-address")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := (*requests)[0]
+	if req.method != http.MethodGet || req.path != "/rest/ip/arp" || req.query["address"] != "10.0.0.1" || req.query[".proplist"] != ".id,address,mac-address" {
+		t.Errorf("request %+v", req)
+	}
+	if len(reply.Re) != 1 || reply.Re[0].Map["mac-address"] != "AA:BB:CC:DD:EE:FF" || reply.Re[0].Map["dynamic"] != "true" || reply.Re[0].Map["timeout"] != "300" {
+		t.Errorf("reply %+v", reply.Re)
+	}
+}
+
+func TestRESTSetAndCommands(t *testing.T) {
+	c, requests := restServer(t, func(w http.ResponseWriter, req restRequest) {
+		if req.method == http.MethodPost {
+			w.Write([]byte(`{"ret":"*5"}`))
+			return
+		}
+		w.Write([]byte(`{".id":"*1A","comment":"changed"}`))
+	})
+	for _, id := range []string{"=numbers=*1A", "=.id=*1A"} {
+		if _, err := c.Run("/ip/dhcp-server/lease/set", id, "=comment=changed"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, req := range *requests {
+		if req.method != http.MethodPatch || req.path != "/rest/ip/dhcp-server/lease/*1A" || len(req.body) != 1 || req.body["comment"] != "changed" {
+			t.Errorf("set %+v", req)
+		}
+	}
+	if _, err := c.Run("/ip/dhcp-server/lease/set", "=comment=changed"); err == nil || len(*requests) != 2 {
+		t.Error("set without the id of the item is sent")
+	}
+
+	reply, err := c.Run("/ip/firewall/address-list/add", "=list=blocked", "=address=10.0.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := (*requests)[2]
+	if req.method != http.MethodPost || req.path != "/rest/ip/firewall/address-list/add" || req.body["list"] != "blocked" || req.body["address"] != "10.0.0.5" {
+		t.Errorf("command %+v", req)
+	}
+	if reply.Done.Map["ret"] != "*5" {
+		t.Errorf("reply %+v", reply.Done)
+	}
+
+	if _, err := c.Run("/ip/firewall/address-list/remove", "?list=blocked"); err != nil {
+		t.Fatal(err)
+	}
+	if query, _ := (*requests)[3].body[".query"].([]interface{}); len(query) != 1 || query[0] != "list=blocked" {
+		t.Errorf("query %+v", (*requests)[3].body)
+	}
+}
+
+func TestRESTErrors(t *testing.T) {
+	c, _ := restServer(t, func(w http.ResponseWriter, req restRequest) {
+		switch req.path {
+		case "/rest/ip/arp":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":400,"message":"Bad Request","detail":"unknown parameter addres"}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`not json`))
+		}
+	})
+	_, err := c.Run("/ip/arp/print", "?addres=10.0.0.1")
+	if err == nil || !strings.Contains(err.Error(), "Bad Request") || !strings.Contains(err.Error(), "unknown parameter addres") {
+		t.Errorf("error %v", err)
+	}
+	if _, err := c.Run("/ip/route/print"); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("error %v", err)
+	}
+	for _, sentence := range [][]string{{"ip/arp/print"}, {"/ip/arp/print", "?#|"}, {"/ip/arp/print", "address"}} {
+		if _, err := c.Run(sentence...); err == nil {
+			t.Errorf("%v: no error", sentence)
+		}
+	}
+
+	if _, err := dialREST(strings.TrimSuffix(strings.TrimPrefix(c.baseURL, "http://"), "/rest"), "api", "wrong", false, false, time.Second); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("wrong password: %v", err)
+	}
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SNMP v2c and v3 (USM, RFC 3414 and RFC 3826) client, only what is needed to walk tables.
+
+const (
+	snmpGetRequest     = 0xa0
+	snmpGetBulkRequest = 0xa5
+	snmpResponse       = 0xa2
+	snmpReport         = 0xa8
+
+	snmpNoSuchObject   = 0x80
+	snmpNoSuchInstance = 0x81
+	snmpEndOfMibView   = 0x82
+
+	snmpFlagAuth       = 0x01
+	snmpFlagPriv       = 0x02
+	snmpFlagReportable = 0x04
+
+	snmpMaxRepetitions = 25
+)
+
+type snmpVarBind struct {
+	oid   []int
+	tag   byte
+	value []byte
+}
+
+type snmpClient struct {
+	address,
+	version,
+	community,
+	user,
+	authProto,
+	authPass,
+	privProto,
+	privPass string
+	timeout time.Duration
+
+	engineID    []byte
+	engineBoots int64
+	engineTime  int64
+	discovered  time.Time
+	authKey     []byte
+	privKey     []byte
+	requestID   int32
+	salt        uint64
+	sync.Mutex
+}
+
+// newSNMPClient parses "v2c:community" or "v3:user:MD5|SHA:authpass:DES|AES:privpass",
+// the authentication and privacy parts of v3 are optional.
+func newSNMPClient(address, params string, timeout time.Duration) (*snmpClient, error) {
+	arr := strings.Split(params, ":")
+	c := &snmpClient{address: address, version: arr[0], timeout: timeout}
+	switch {
+	case (c.version == "v2c" || c.version == "2c") && len(arr) == 2:
+		c.version = "2c"
+		c.community = arr[1]
+	case (c.version == "v3" || c.version == "3") && (len(arr) == 2 || len(arr) == 4 || len(arr) == 6):
+		c.version = "3"
+		c.user = arr[1]
+		if len(arr) >= 4 && (len(arr[3]) < 8 || len(arr) == 6 && len(arr[5]) < 8) {
+			return nil, fmt.Errorf("SNMPv3 passwords must be at least 8 characters long")
+		}
+		if len(arr) >= 4 {
+			c.authProto, c.authPass = strings.ToUpper(arr[2]), arr[3]
+			if c.authProto != "MD5" && c.authProto != "SHA" {
+				return nil, fmt.Errorf("unknown authentication protocol:%v", arr[2])
+			}
+		}
+		if len(arr) == 6 {
+			c.privProto, c.privPass = strings.ToUpper(arr[4]), arr[5]
+			if c.privProto != "DES" && c.privProto != "AES" {
+				return nil, fmt.Errorf("unknown privacy protocol:%v", arr[4])
+			}
+		}
+	default:
+		return nil, fmt.Errorf("wrong SNMP parameters(%v), the format is v2c:community or v3:user:MD5|SHA:authpass:DES|AES:privpass", params)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		c.address = net.JoinHostPort(address, "161")
+	}
+	return c, nil
+}
+
+// walk calls fn for every variable in the subtree of the OID.
+func (c *snmpClient) walk(root []int, fn func(vb snmpVarBind)) error {
+	c.Lock()
+	defer c.Unlock()
+	conn, err := net.Dial("udp", c.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	oid := root
+	for {
+		vbs, err := c.request(conn, snmpGetBulkRequest, oid)
+		if err != nil {
+			return err
+		}
+		if len(vbs) == 0 {
+			return nil
+		}
+		for _, vb := range vbs {
+			if vb.tag == snmpEndOfMibView || !oidHasPrefix(vb.oid, root) {
+				return nil
+			}
+			// An agent returning the same OID again would be walked forever
+			if oidCompare(vb.oid, oid) <= 0 {
+				return fmt.Errorf("snmp: OID %v is not after %v", oidString(vb.oid), oidString(oid))
+			}
+			oid = vb.oid
+			if vb.tag == snmpNoSuchObject || vb.tag == snmpNoSuchInstance {
+				continue
+			}
+			fn(vb)
+		}
+	}
+}
+
+func (c *snmpClient) request(conn net.Conn, pduType byte, oid []int) ([]snmpVarBind, error) {
+	if c.version == "3" {
+		vbs, err := c.requestV3(conn, pduType, oid)
+		if err == errSNMPReport {
+			// The engine could have been rebooted or the time window is lost, the engine is discovered again
+			c.engineID = nil
+			vbs, err = c.requestV3(conn, pduType, oid)
+		}
+		return vbs, err
+	}
+	msg := berConstructed(berSequence,
+		berInt(berInteger, 1),
+		berString(berOctetString, c.community),
+		c.pdu(pduType, oid),
+	)
+	requestID := int64(c.requestID)
+	resp, err := c.exchange(conn, msg, func(resp []byte) bool {
+		elements, err := parseSNMPSequence(resp, 3)
+		return err == nil && snmpRequestID(elements[2]) == requestID
+	})
+	if err != nil {
+		return nil, err
+	}
+	elements, err := parseSNMPSequence(resp, 3)
+	if err != nil {
+		return nil, err
+	}
+	return parseSNMPPDU(elements[2], requestID)
+}
+
+func (c *snmpClient) pdu(pduType byte, oid []int) []byte {
+	c.requestID++
+	varBinds := []byte{}
+	if oid != nil {
+		varBinds = berConstructed(berSequence, berEncodeOID(oid), berEncode(berNull, nil))
+	}
+	nonRepeaters, maxRepetitions := int64(0), int64(0)
+	if pduType == snmpGetBulkRequest {
+		maxRepetitions = snmpMaxRepetitions
+	}
+	return berConstructed(pduType,
+		berInt(berInteger, int64(c.requestID)),
+		berInt(berInteger, nonRepeaters),
+		berInt(berInteger, maxRepetitions),
+		berEncode(berSequence, varBinds),
+	)
+}
+
+// exchange sends the message and waits for the response it expects, the late responses to the earlier requests
+// or to the earlier tries are skipped.
+func (c *snmpClient) exchange(conn net.Conn, msg []byte, expected func(resp []byte) bool) ([]byte, error) {
+	buf := make([]byte, 65536)
+	for try := 0; try < 3; try++ {
+		if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if expected(buf[:n]) {
+				return buf[:n], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no response from %v", c.address)
+}
+
+func parseSNMPSequence(b []byte, min int) ([]berElement, error) {
+	tag, content, _, err := berRead(b)
+	if err != nil {
+		return nil, err
+	}
+	if tag != berSequence {
+		return nil, errors.New("snmp: message is not a sequence")
+	}
+	elements, err := berElements(content)
+	if err != nil {
+		return nil, err
+	}
+	if len(elements) < min {
+		return nil, errors.New("snmp: short message")
+	}
+	return elements, nil
+}
+
+var errSNMPReport = errors.New("snmp: report received")
+
+// snmpRequestID returns the request-id of the PDU, -1 if it is not a PDU.
+func snmpRequestID(pdu berElement) int64 {
+	elements, err := berElements(pdu.content)
+	if err != nil || len(elements) != 4 || elements[0].tag != berInteger {
+		return -1
+	}
+	return berParseInt(elements[0].content)
+}
+
+func parseSNMPPDU(pdu berElement, requestID int64) ([]snmpVarBind, error) {
+	if pdu.tag == snmpReport {
+		return nil, errSNMPReport
+	}
+	if pdu.tag != snmpResponse {
+		return nil, fmt.Errorf("snmp: unexpected PDU type 0x%x", pdu.tag)
+	}
+	elements, err := berElements(pdu.content)
+	if err != nil {
+		return nil, err
+	}
+	if len(elements) != 4 {
+		return nil, errors.New("snmp: wrong PDU")
+	}
+	if id := berParseInt(elements[0].content); id != requestID {
+		return nil, fmt.Errorf("snmp: response to the request %v instead of %v", id, requestID)
+	}
+	if status := berParseInt(elements[1].content); status != 0 {
+		return nil, fmt.Errorf("snmp: error-status %v", status)
+	}
+	list, err := berElements(elements[3].content)
+	if err != nil {
+		return nil, err
+	}
+	vbs := []snmpVarBind{}
+	for _, item := range list {
+		pair, err := berElements(item.content)
+		if err != nil || len(pair) != 2 || pair[0].tag != berOID {
+			return nil, errors.New("snmp: wrong variable binding")
+		}
+		vbs = append(vbs, snmpVarBind{oid: berParseOID(pair[0].content), tag: pair[1].tag, value: pair[1].content})
+	}
+	return vbs, nil
+}
+
+// SNMPv3
+
+func (c *snmpClient) hash() func() hash.Hash {
+	if c.authProto == "SHA" {
+		return sha1.New
+	}
+	return md5.New
+}
+
+// passwordToKey is the key localization from RFC 3414 A.2.
+func passwordToKey(newHash func() hash.Hash, password string, engineID []byte) []byte {
+	h := newHash()
+	buf := make([]byte, 64)
+	for i := 0; i < 1048576; i += 64 {
+		for j := range buf {
+			buf[j] = password[(i+j)%len(password)]
+		}
+		h.Write(buf)
+	}
+	ku := h.Sum(nil)
+	h.Reset()
+	h.Write(ku)
+	h.Write(engineID)
+	h.Write(ku)
+	return h.Sum(nil)
+}
+
+func (c *snmpClient) flags() byte {
+	flags := byte(snmpFlagReportable)
+	if c.authProto != "" {
+		flags |= snmpFlagAuth
+	}
+	if c.privProto != "" {
+		flags |= snmpFlagPriv
+	}
+	return flags
+}
+
+func (c *snmpClient) discover(conn net.Conn) error {
+	msg := c.messageV3(snmpFlagReportable, nil, 0, 0, nil, nil,
+		berConstructed(berSequence, berString(berOctetString, ""), berString(berOctetString, ""), c.pdu(snmpGetRequest, nil)))
+	resp, err := c.exchange(conn, msg, c.expectedMessageID())
+	if err != nil {
+		return err
+	}
+	params, _, err := parseSNMPv3(resp)
+	if err != nil {
+		return err
+	}
+	if len(params.engineID) == 0 {
+		return errors.New("snmp: engine ID is not discovered")
+	}
+	c.engineID = params.engineID
+	c.engineBoots = params.boots
+	c.engineTime = params.time
+	c.discovered = time.Now()
+	if c.authProto != "" {
+		c.authKey = passwordToKey(c.hash(), c.authPass, c.engineID)
+	}
+	if c.privProto != "" {
+		c.privKey = passwordToKey(c.hash(), c.privPass, c.engineID)
+	}
+	return nil
+}
+
+func (c *snmpClient) currentEngineTime() int64 {
+	return c.engineTime + int64(time.Since(c.discovered).Seconds())
+}
+
+func (c *snmpClient) requestV3(conn net.Conn, pduType byte, oid []int) ([]snmpVarBind, error) {
+	if c.engineID == nil {
+		if err := c.discover(conn); err != nil {
+			return nil, err
+		}
+	}
+	scopedPDU := berConstructed(berSequence,
+		berEncode(berOctetString, c.engineID),
+		berString(berOctetString, ""),
+		c.pdu(pduType, oid),
+	)
+	engineTime := c.currentEngineTime()
+	data := scopedPDU
+	var privParams []byte
+	if c.privProto != "" {
+		var err error
+		data, privParams, err = c.encrypt(scopedPDU, engineTime)
+		if err != nil {
+			return nil, err
+		}
+		data = berEncode(berOctetString, data)
+	}
+	msg := c.messageV3(c.flags(), c.engineID, c.engineBoots, engineTime, []byte(c.user), privParams, data)
+
+	requestID := int64(c.requestID)
+	resp, err := c.exchange(conn, msg, c.expectedMessageID())
+	if err != nil {
+		return nil, err
+	}
+	params, msgData, err := parseSNMPv3(resp)
+	if err != nil {
+		return nil, err
+	}
+	if c.authProto != "" && params.flags&snmpFlagAuth == 0 || c.privProto != "" && params.flags&snmpFlagPriv == 0 {
+		// Only a report, e.g. of the unknown engine ID, may come without the security of the request
+		if elements, err := berElements(msgData.content); err == nil && msgData.tag == berSequence && len(elements) == 3 && elements[2].tag == snmpReport {
+			return nil, errSNMPReport
+		}
+		return nil, errors.New("snmp: the response is without the authentication or privacy of the request")
+	}
+	if c.authProto != "" {
+		if !c.checkAuth(resp, params.authParams) {
+			return nil, errors.New("snmp: wrong authentication of the response")
+		}
+	}
+	if params.flags&snmpFlagPriv != 0 {
+		if msgData.tag != berOctetString {
+			return nil, errors.New("snmp: encrypted data is not an octet string")
+		}
+		plain, err := c.decrypt(msgData.content, params)
+		if err != nil {
+			return nil, err
+		}
+		_, content, _, err := berRead(plain)
+		if err != nil {
+			return nil, err
+		}
+		msgData = berElement{berSequence, content}
+	}
+	if msgData.tag != berSequence {
+		return nil, errors.New("snmp: wrong scoped PDU")
+	}
+	elements, err := berElements(msgData.content)
+	if err != nil || len(elements) != 3 {
+		return nil, errors.New("snmp: wrong scoped PDU")
+	}
+	return parseSNMPPDU(elements[2], requestID)
+}
+
+func (c *snmpClient) messageV3(flags byte, engineID []byte, boots, engineTime int64, user, privParams, data []byte) []byte {
+	authParams := []byte{}
+	if flags&snmpFlagAuth != 0 {
+		authParams = make([]byte, 12)
+	}
+	secParams := berConstructed(berSequence,
+		berEncode(berOctetString, engineID),
+		berInt(berInteger, boots),
+		berInt(berInteger, engineTime),
+		berEncode(berOctetString, user),
+		berEncode(berOctetString, authParams),
+		berEncode(berOctetString, privParams),
+	)
+	msg := berConstructed(berSequence,
+		berInt(berInteger, 3),
+		berConstructed(berSequence,
+			berInt(berInteger, int64(c.requestID)),
+			berInt(berInteger, 65507),
+			berEncode(berOctetString, []byte{flags}),
+			berInt(berInteger, 3),
+		),
+		berEncode(berOctetString, secParams),
+		data,
+	)
+	if flags&snmpFlagAuth != 0 {
+		// authParams are the 12 zero bytes right after the user name in the security parameters
+		prefix := append(berEncode(berOctetString, engineID), berInt(berInteger, boots)...)
+		prefix = append(prefix, berInt(berInteger, engineTime)...)
+		prefix = append(prefix, berEncode(berOctetString, user)...)
+		offset := bytes.Index(msg, secParams) + (len(secParams) - secParamsContentLen(secParams)) + len(prefix) + 2
+		mac := hmac.New(c.hash(), c.authKey)
+		mac.Write(msg)
+		copy(msg[offset:offset+12], mac.Sum(nil)[:12])
+	}
+	return msg
+}
+
+func secParamsContentLen(secParams []byte) int {
+	_, content, _, _ := berRead(secParams)
+	return len(content)
+}
+
+// expectedMessageID matches the responses to the last message, the msgID of v3 is the request-id of its PDU.
+func (c *snmpClient) expectedMessageID() func(resp []byte) bool {
+	msgID := int64(c.requestID)
+	return func(resp []byte) bool {
+		params, _, err := parseSNMPv3(resp)
+		return err == nil && params.msgID == msgID
+	}
+}
+
+type snmpSecurityParams struct {
+	msgID      int64
+	flags      byte
+	engineID   []byte
+	boots      int64
+	time       int64
+	authParams []byte
+	privParams []byte
+}
+
+func parseSNMPv3(resp []byte) (params snmpSecurityParams, msgData berElement, err error) {
+	elements, err := parseSNMPSequence(resp, 4)
+	if err != nil {
+		return params, msgData, err
+	}
+	if berParseInt(elements[0].content) != 3 {
+		return params, msgData, errors.New("snmp: not a v3 message")
+	}
+	global, err := berElements(elements[1].content)
+	if err != nil || len(global) != 4 || len(global[2].content) != 1 {
+		return params, msgData, errors.New("snmp: wrong global data")
+	}
+	params.msgID = berParseInt(global[0].content)
+	params.flags = global[2].content[0]
+	sec, err := parseSNMPSequence(elements[2].content, 6)
+	if err != nil {
+		return params, msgData, err
+	}
+	params.engineID = sec[0].content
+	params.boots = berParseInt(sec[1].content)
+	params.time = berParseInt(sec[2].content)
+	params.authParams = sec[4].content
+	params.privParams = sec[5].content
+	return params, elements[3], nil
+}
+
+// checkAuth checks HMAC-96 of the response, the authParams are a part of the response buffer.
+func (c *snmpClient) checkAuth(resp, authParams []byte) bool {
+	if len(authParams) != 12 {
+		return false
+	}
+	offset := cap(resp) - cap(authParams)
+	if offset < 0 || offset+12 > len(resp) {
+		return false
+	}
+	msg := append([]byte{}, resp...)
+	copy(msg[offset:offset+12], make([]byte, 12))
+	mac := hmac.New(c.hash(), c.authKey)
+	mac.Write(msg)
+	return hmac.Equal(mac.Sum(nil)[:12], authParams)
+}
+
+func (c *snmpClient) encrypt(plain []byte, engineTime int64) (data, privParams []byte, err error) {
+	c.salt++
+	if c.salt == 1 {
+		// a random start of the salt, as recommended by RFC 3414
+		seed := make([]byte, 8)
+		if _, err := rand.Read(seed); err == nil {
+			c.salt = binary.BigEndian.Uint64(seed)
+		}
+	}
+	privParams = make([]byte, 8)
+	switch c.privProto {
+	case "DES":
+		binary.BigEndian.PutUint32(privParams, uint32(c.engineBoots))
+		binary.BigEndian.PutUint32(privParams[4:], uint32(c.salt))
+		block, err := des.NewCipher(c.privKey[:8])
+		if err != nil {
+			return nil, nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = c.privKey[8+i] ^ privParams[i]
+		}
+		if pad := len(plain) % 8; pad != 0 {
+			plain = append(plain, make([]byte, 8-pad)...)
+		}
+		data = make([]byte, len(plain))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, plain)
+	case "AES":
+		binary.BigEndian.PutUint64(privParams, c.salt)
+		block, err := aes.NewCipher(c.privKey[:16])
+		if err != nil {
+			return nil, nil, err
+		}
+		iv := make([]byte, 16)
+		binary.BigEndian.PutUint32(iv, uint32(c.engineBoots))
+		binary.BigEndian.PutUint32(iv[4:], uint32(engineTime))
+		copy(iv[8:], privParams)
+		data = make([]byte, len(plain))
+		cipher.NewCFBEncrypter(block, iv).XORKeyStream(data, plain)
+	}
+	return data, privParams, nil
+}
+
+func (c *snmpClient) decrypt(data []byte, params snmpSecurityParams) ([]byte, error) {
+	if len(params.privParams) != 8 {
+		return nil, errors.New("snmp: wrong privacy parameters")
+	}
+	plain := make([]byte, len(data))
+	switch c.privProto {
+	case "DES":
+		if len(data)%8 != 0 {
+			return nil, errors.New("snmp: wrong length of encrypted data")
+		}
+		block, err := des.NewCipher(c.privKey[:8])
+		if err != nil {
+			return nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = c.privKey[8+i] ^ params.privParams[i]
+		}
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	case "AES":
+		block, err := aes.NewCipher(c.privKey[:16])
+		if err != nil {
+			return nil, err
+		}
+		iv := make([]byte, 16)
+		binary.BigEndian.PutUint32(iv, uint32(params.boots))
+		binary.BigEndian.PutUint32(iv[4:], uint32(params.time))
+		copy(iv[8:], params.privParams)
+		cipher.NewCFBDecrypter(block, iv).XORKeyStream(plain, data)
+	default:
+		return nil, errors.New("snmp: privacy is not configured")
+	}
+	return plain, nil
+}
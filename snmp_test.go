@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBERInt(t *testing.T) {
+	for _, v := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 1<<31 - 1, -1 << 31, 1<<62 + 5} {
+		tag, content, rest, err := berRead(berInt(berInteger, v))
+		if err != nil || tag != berInteger || len(rest) != 0 {
+			t.Fatalf("%v: %v %v %v", v, tag, rest, err)
+		}
+		if got := berParseInt(content); got != v {
+			t.Errorf("%v decoded as %v", v, got)
+		}
+	}
+}
+
+func TestBERLength(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 255, 256, 70000} {
+		content := bytes.Repeat([]byte{'a'}, n)
+		_, got, _, err := berRead(berEncode(berOctetString, content))
+		if err != nil || len(got) != n {
+			t.Errorf("%v: %v %v", n, len(got), err)
+		}
+	}
+	broken := map[string][]byte{
+		"short":              {berOctetString},
+		"indefinite length":  {berOctetString, 0x80, 0, 0},
+		"length of 5 octets": {berOctetString, 0x85, 1, 0, 0, 0, 0},
+		"over the buffer":    {berOctetString, 0x81, 0xff, 'a'},
+	}
+	for name, b := range broken {
+		if _, _, _, err := berRead(b); err == nil {
+			t.Errorf("%v: no error", name)
+		}
+	}
+}
+
+func TestBEROID(t *testing.T) {
+	for _, oid := range [][]int{{1, 3, 6, 1, 2, 1, 31, 1, 1, 1, 1, 3}, {1, 3, 6, 1, 4, 1, 14988, 1, 1, 1, 2, 1, 3, 4294967295}} {
+		_, content, _, err := berRead(berEncodeOID(oid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := berParseOID(content); oidCompare(got, oid) != 0 {
+			t.Errorf("%v decoded as %v", oid, got)
+		}
+	}
+	if got := parseOID(".1.3.6.1.2.1.4.35.1.4"); oidString(got) != "1.3.6.1.2.1.4.35.1.4" {
+		t.Errorf("parseOID %v", got)
+	}
+}
+
+func TestOIDCompare(t *testing.T) {
+	cases := []struct {
+		a, b []int
+		want int
+	}{
+		{[]int{1, 3, 6}, []int{1, 3, 6}, 0},
+		{[]int{1, 3, 6}, []int{1, 3, 6, 1}, -1},
+		{[]int{1, 3, 7}, []int{1, 3, 6, 1}, 1},
+		{[]int{1, 3, 6, 2}, []int{1, 3, 6, 10}, -1},
+	}
+	for _, c := range cases {
+		if got := oidCompare(c.a, c.b); got < 0 && c.want >= 0 || got > 0 && c.want <= 0 || got == 0 && c.want != 0 {
+			t.Errorf("oidCompare(%v, %v) = %v", c.a, c.b, got)
+		}
+	}
+}
+
+// The test vectors of RFC 3414 A.3.
+func TestPasswordToKey(t *testing.T) {
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+	c := &snmpClient{authProto: "MD5"}
+	if got := hex.EncodeToString(passwordToKey(c.hash(), "maplesyrup", engineID)); got != "526f5eed9fcce26f8964c2930787d82b" {
+		t.Errorf("MD5 %v", got)
+	}
+	c.authProto = "SHA"
+	if got := hex.EncodeToString(passwordToKey(c.hash(), "maplesyrup", engineID)); got != "6695febc9288e36282235fc7151f128497b38f3f" {
+		t.Errorf("SHA %v", got)
+	}
+}
+
+// snmpAgent answers the requests on a local UDP port with the messages returned by handle.
+func snmpAgent(t *testing.T, handle func(req []byte) [][]byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			for _, resp := range handle(append([]byte{}, buf[:n]...)) {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+var (
+	testIfName = []int{1, 3, 6, 1, 2, 1, 31, 1, 1, 1, 1}
+	testTable  = []snmpVarBind{
+		{oid: append(append([]int{}, testIfName...), 1), tag: berOctetString, value: []byte("ether1")},
+		{oid: append(append([]int{}, testIfName...), 2), tag: berOctetString, value: []byte("ether2")},
+		{oid: append(append([]int{}, testIfName...), 7), tag: berOctetString, value: []byte("vlan10")},
+		{oid: []int{1, 3, 6, 1, 2, 1, 31, 1, 1, 1, 2, 1}, tag: berInteger, value: []byte{0}},
+	}
+)
+
+// getBulk returns the variables of the table after the OID, as many as maxRepetitions.
+func getBulk(oid []int, maxRepetitions int) []byte {
+	vbs := [][]byte{}
+	for _, vb := range testTable {
+		if oidCompare(vb.oid, oid) > 0 && len(vbs) < maxRepetitions {
+			vbs = append(vbs, berConstructed(berSequence, berEncodeOID(vb.oid), berEncode(vb.tag, vb.value)))
+		}
+	}
+	if len(vbs) == 0 {
+		vbs = append(vbs, berConstructed(berSequence, berEncodeOID(oid), berEncode(snmpEndOfMibView, nil)))
+	}
+	return berConstructed(berSequence, vbs...)
+}
+
+func responsePDU(requestID int64, varBinds []byte) []byte {
+	return berConstructed(snmpResponse, berInt(berInteger, requestID), berInt(berInteger, 0), berInt(berInteger, 0), varBinds)
+}
+
+// parseRequestPDU returns the request-id and the OID of the first variable of the PDU.
+func parseRequestPDU(pdu berElement) (int64, []int) {
+	elements, _ := berElements(pdu.content)
+	list, _ := berElements(elements[3].content)
+	if len(list) == 0 {
+		return berParseInt(elements[0].content), nil
+	}
+	pair, _ := berElements(list[0].content)
+	return berParseInt(elements[0].content), berParseOID(pair[0].content)
+}
+
+func v2cMessage(requestID int64, varBinds []byte) []byte {
+	return berConstructed(berSequence, berInt(berInteger, 1), berString(berOctetString, "public"), responsePDU(requestID, varBinds))
+}
+
+func walkAll(c *snmpClient, root []int) ([]string, error) {
+	values := []string{}
+	err := c.walk(root, func(vb snmpVarBind) {
+		values = append(values, string(vb.value))
+	})
+	return values, err
+}
+
+func TestSNMPWalkV2c(t *testing.T) {
+	address := snmpAgent(t, func(req []byte) [][]byte {
+		elements, err := parseSNMPSequence(req, 3)
+		if err != nil {
+			return nil
+		}
+		requestID, oid := parseRequestPDU(elements[2])
+		// The late answer to the previous request comes first
+		stale := v2cMessage(requestID-1, berConstructed(berSequence,
+			berConstructed(berSequence, berEncodeOID(append(append([]int{}, testIfName...), 99)), berString(berOctetString, "stale"))))
+		return [][]byte{stale, v2cMessage(requestID, getBulk(oid, 2))}
+	})
+	c, err := newSNMPClient(address, "v2c:public", 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := walkAll(c, testIfName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || values[0] != "ether1" || values[1] != "ether2" || values[2] != "vlan10" {
+		t.Errorf("values %v", values)
+	}
+}
+
+func TestSNMPWalkNotIncreasing(t *testing.T) {
+	for name, tag := range map[string]byte{"same OID": berOctetString, "noSuchInstance": snmpNoSuchInstance} {
+		tag := tag
+		address := snmpAgent(t, func(req []byte) [][]byte {
+			elements, err := parseSNMPSequence(req, 3)
+			if err != nil {
+				return nil
+			}
+			requestID, oid := parseRequestPDU(elements[2])
+			// The agent answers with the requested OID instead of the next one
+			if len(oid) == len(testIfName) {
+				oid = append(oid, 1)
+			}
+			return [][]byte{v2cMessage(requestID, berConstructed(berSequence, berConstructed(berSequence, berEncodeOID(oid), berEncode(tag, []byte("x")))))}
+		})
+		c, _ := newSNMPClient(address, "v2c:public", 200*time.Millisecond)
+		done := make(chan error, 1)
+		go func() {
+			_, err := walkAll(c, testIfName)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("%v: no error", name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v: the walk does not end", name)
+		}
+	}
+}
+
+// v3Agent is the USM side of the exporter with the same keys as the client, it responds with the flags.
+func v3Agent(t *testing.T, params string, flags byte) string {
+	engineID := []byte{0x80, 0, 0x3a, 0x8c, 4, 'g', 'o', 'n', 's'}
+	agent, err := newSNMPClient("agent", params, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.engineID, agent.engineBoots = engineID, 3
+	agent.authKey = passwordToKey(agent.hash(), agent.authPass, engineID)
+	agent.privKey = passwordToKey(agent.hash(), agent.privPass, engineID)
+	const engineTime = 1000
+
+	return snmpAgent(t, func(req []byte) [][]byte {
+		params, msgData, err := parseSNMPv3(req)
+		if err != nil {
+			return nil
+		}
+		agent.requestID = int32(params.msgID)
+		if len(params.engineID) == 0 {
+			report := berConstructed(snmpReport, berInt(berInteger, params.msgID), berInt(berInteger, 0), berInt(berInteger, 0), berEncode(berSequence, nil))
+			return [][]byte{agent.messageV3(0, engineID, agent.engineBoots, engineTime, nil, nil,
+				berConstructed(berSequence, berEncode(berOctetString, engineID), berString(berOctetString, ""), report))}
+		}
+		if !agent.checkAuth(req, params.authParams) {
+			return nil
+		}
+		plain, err := agent.decrypt(msgData.content, params)
+		if err != nil {
+			return nil
+		}
+		_, content, _, err := berRead(plain)
+		if err != nil {
+			return nil
+		}
+		scoped, err := berElements(content)
+		if err != nil || len(scoped) != 3 {
+			return nil
+		}
+		requestID, oid := parseRequestPDU(scoped[2])
+		respond := func(msgID int64) []byte {
+			agent.requestID = int32(msgID)
+			scopedPDU := berConstructed(berSequence, berEncode(berOctetString, engineID), berString(berOctetString, ""), responsePDU(requestID, getBulk(oid, 25)))
+			if flags&snmpFlagPriv == 0 {
+				return agent.messageV3(flags, engineID, agent.engineBoots, engineTime, []byte(agent.user), nil, scopedPDU)
+			}
+			data, privParams, _ := agent.encrypt(scopedPDU, engineTime)
+			return agent.messageV3(flags, engineID, agent.engineBoots, engineTime, []byte(agent.user), privParams, berEncode(berOctetString, data))
+		}
+		return [][]byte{respond(params.msgID - 1), respond(params.msgID)}
+	})
+}
+
+func TestSNMPWalkV3(t *testing.T) {
+	for _, params := range []string{"v3:monitor:SHA:authpass1:AES:privpass1", "v3:monitor:MD5:authpass1:DES:privpass1"} {
+		c, err := newSNMPClient(v3Agent(t, params, snmpFlagAuth|snmpFlagPriv), params, 200*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values, err := walkAll(c, testIfName)
+		if err != nil {
+			t.Fatalf("%v: %v", params, err)
+		}
+		if len(values) != 3 || values[0] != "ether1" || values[2] != "vlan10" {
+			t.Errorf("%v: values %v", params, values)
+		}
+	}
+}
+
+func TestSNMPv3WrongPassword(t *testing.T) {
+	address := v3Agent(t, "v3:monitor:SHA:authpass1:AES:privpass1", snmpFlagAuth|snmpFlagPriv)
+	c, _ := newSNMPClient(address, "v3:monitor:SHA:wrongpass:AES:privpass1", 100*time.Millisecond)
+	if _, err := walkAll(c, testIfName); err == nil {
+		t.Error("the walk with a wrong password succeeded")
+	}
+}
+
+// A response without the authentication or privacy of the request could be spoofed.
+func TestSNMPv3Downgrade(t *testing.T) {
+	params := "v3:monitor:SHA:authpass1:AES:privpass1"
+	for _, flags := range []byte{0, snmpFlagAuth} {
+		c, _ := newSNMPClient(v3Agent(t, params, flags), params, 100*time.Millisecond)
+		if values, err := walkAll(c, testIfName); err == nil {
+			t.Errorf("flags %v: the response is accepted, values %v", flags, values)
+		}
+	}
+}
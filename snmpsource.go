@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	oidIfName                  = parseOID("1.3.6.1.2.1.31.1.1.1.1")
+	oidIPNetToPhysicalPhysAddr = parseOID("1.3.6.1.2.1.4.35.1.4")
+	oidIPNetToMediaPhysAddr    = parseOID("1.3.6.1.2.1.4.22.1.2")
+)
+
+type snmpExporter struct {
+	name      string
+	client    *snmpClient
+	neighbors Neighbors
+	ifNames   map[int]string
+}
+
+// SNMPSource are the ARP tables of the NetFlow exporters, which are not Mikrotik routers, polled over SNMP.
+type SNMPSource struct {
+	exporters []*snmpExporter
+	sync.RWMutex
+}
+
+func newSNMPSource(list []string, timeout string) *SNMPSource {
+	source := &SNMPSource{}
+	t, err := time.ParseDuration(timeout)
+	if err != nil {
+		t = 5 * time.Second
+	}
+	for _, value := range list {
+		if value == "" {
+			continue
+		}
+		arr := strings.SplitN(value, "=", 2)
+		if len(arr) != 2 {
+			log.Errorf("Error parse SNMP exporter(%v), the format is exporter=v2c:community or exporter=v3:user:MD5|SHA:authpass:DES|AES:privpass", value)
+			continue
+		}
+		client, err := newSNMPClient(arr[0], arr[1], t)
+		if err != nil {
+			log.Errorf("Error parse SNMP exporter(%v):%v", arr[0], err)
+			continue
+		}
+		source.exporters = append(source.exporters, &snmpExporter{name: arr[0], client: client})
+	}
+	return source
+}
+
+func (data *Transport) loopPollSNMP() {
+	for {
+		for _, exporter := range data.snmp.exporters {
+			if err := data.snmp.poll(exporter); err != nil {
+				log.Errorf("Error getting the ARP table from %v over SNMP:%v", exporter.name, err)
+			}
+		}
+		data.Lock()
+		data.snmp.mergeInto(data.ipToMac, data.QuotaType)
+		data.Unlock()
+
+		interval, err := time.ParseDuration(cfg.SNMPInterval)
+		if err != nil {
+			interval = 5 * time.Minute
+		}
+		time.Sleep(interval)
+	}
+}
+
+// poll reads ifName and the ARP table of the exporter. ipNetToPhysicalTable is used when the agent supports it,
+// otherwise the deprecated ipNetToMediaTable.
+func (s *SNMPSource) poll(exporter *snmpExporter) error {
+	ifNames := map[int]string{}
+	err := exporter.client.walk(oidIfName, func(vb snmpVarBind) {
+		if len(vb.oid) == len(oidIfName)+1 {
+			ifNames[vb.oid[len(oidIfName)]] = string(vb.value)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	table := map[string]neighbor{}
+	add := func(ifIndex int, ip net.IP, mac []byte) {
+		if len(mac) != 6 || ip == nil || ip.IsUnspecified() {
+			return
+		}
+		hw := strings.ToUpper(net.HardwareAddr(mac).String())
+		if hw == "00:00:00:00:00:00" || hw == "FF:FF:FF:FF:FF:FF" {
+			return
+		}
+		iface, ok := ifNames[ifIndex]
+		if !ok {
+			iface = strconv.Itoa(ifIndex)
+		}
+		table[ip.String()] = neighbor{Mac: hw, Interface: iface}
+	}
+
+	// index: ifIndex.addressType.length.address
+	err = exporter.client.walk(oidIPNetToPhysicalPhysAddr, func(vb snmpVarBind) {
+		index := vb.oid[len(oidIPNetToPhysicalPhysAddr):]
+		if len(index) < 3 || len(index) != 3+index[2] || (index[2] != 4 && index[2] != 16) {
+			return
+		}
+		add(index[0], oidToIP(index[3:]), vb.value)
+	})
+	if err != nil {
+		return err
+	}
+	if len(table) == 0 {
+		// index: ifIndex.a.b.c.d
+		err = exporter.client.walk(oidIPNetToMediaPhysAddr, func(vb snmpVarBind) {
+			index := vb.oid[len(oidIPNetToMediaPhysAddr):]
+			if len(index) != 5 {
+				return
+			}
+			add(index[0], oidToIP(index[1:]), vb.value)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	exporter.neighbors.replace(table)
+	s.Lock()
+	exporter.ifNames = ifNames
+	s.Unlock()
+	log.Debugf("Get %v ARP entries and %v interfaces from %v over SNMP", len(table), len(ifNames), exporter.name)
+	return nil
+}
+
+func oidToIP(parts []int) net.IP {
+	ip := make(net.IP, len(parts))
+	for i, n := range parts {
+		if n < 0 || n > 255 {
+			return nil
+		}
+		ip[i] = byte(n)
+	}
+	return ip
+}
+
+func (s *SNMPSource) apply(line *LineOfData) bool {
+	for _, exporter := range s.exporters {
+		if exporter.neighbors.apply(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SNMPSource) mergeInto(ipToMac map[string]LineOfData, quota QuotaType) {
+	for _, exporter := range s.exporters {
+		exporter.neighbors.mergeInto(ipToMac, quota)
+	}
+}
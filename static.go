@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	log "github.com/sirupsen/logrus"
+)
+
+type staticDevice struct {
+	IP           string `toml:"ip"`
+	Mac          string `toml:"mac"`
+	HostName     string `toml:"hostname"`
+	Name         string `toml:"name"`
+	Company      string `toml:"company"`
+	Position     string `toml:"position"`
+	Type         string `toml:"type"`
+	QuotaHourly  uint64 `toml:"quota_hourly"`
+	QuotaDaily   uint64 `toml:"quota_daily"`
+	QuotaMonthly uint64 `toml:"quota_monthly"`
+}
+
+// StaticDevices are the devices with fixed addresses described in the mapping file (TOML or CSV).
+// With the high priority they override the data of the router, with the low one they only fill the gaps.
+type StaticDevices struct {
+	path    string
+	high    bool
+	modTime time.Time
+	size    int64
+	byIP    map[string]staticDevice
+	byMac   map[string]staticDevice
+	sync.RWMutex
+}
+
+func newStaticDevices(path, priority string) *StaticDevices {
+	if priority != "high" && priority != "low" {
+		log.Errorf("Error parse the priority of the static devices(%v), it is high or low. Installed by default = low", priority)
+	}
+	return &StaticDevices{path: path, high: priority == "high"}
+}
+
+func (data *Transport) loopReadStaticDevices() {
+	for {
+		changed, err := data.static.read()
+		if err != nil {
+			log.Errorf("Error reading the static devices from %v:%v", data.static.path, err)
+		} else if changed {
+			data.Lock()
+			data.static.mergeInto(data.ipToMac, data.QuotaType)
+			data.Unlock()
+		}
+
+		interval, err := time.ParseDuration(cfg.StaticDevicesInterval)
+		if err != nil {
+			interval = 10 * time.Second
+		}
+		time.Sleep(interval)
+	}
+}
+
+// read re-reads the file if it has changed since the last reading.
+func (s *StaticDevices) read() (bool, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false, err
+	}
+	s.RLock()
+	same := info.ModTime().Equal(s.modTime) && info.Size() == s.size
+	s.RUnlock()
+	if same {
+		return false, nil
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	var devices []staticDevice
+	if strings.ToLower(filepath.Ext(s.path)) == ".csv" {
+		devices, err = parseStaticCSV(f)
+	} else {
+		var file struct {
+			Device []staticDevice `toml:"device"`
+		}
+		_, err = toml.DecodeReader(f, &file)
+		devices = file.Device
+	}
+	if err != nil {
+		// The old mapping is kept until the file is fixed
+		return false, err
+	}
+
+	byIP, byMac := map[string]staticDevice{}, map[string]staticDevice{}
+	for _, device := range devices {
+		device.Mac = normalizeMac(device.Mac)
+		switch {
+		case device.IP != "":
+			byIP[device.IP] = device
+		case device.Mac != "":
+			byMac[device.Mac] = device
+		default:
+			log.Warningf("The static device %#v has neither IP nor MAC", device)
+		}
+	}
+	s.Lock()
+	s.byIP, s.byMac = byIP, byMac
+	s.modTime, s.size = info.ModTime(), info.Size()
+	s.Unlock()
+	log.Infof("Loaded %v static devices from %v", len(byIP)+len(byMac), s.path)
+	return true, nil
+}
+
+// parseStaticCSV parses the CSV file with a header, the columns are the same as the keys of the TOML file.
+func parseStaticCSV(r io.Reader) ([]staticDevice, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	devices := []staticDevice{}
+	for n, record := range records[1:] {
+		device := staticDevice{}
+		for i, value := range record {
+			if i >= len(header) {
+				break
+			}
+			value = strings.TrimSpace(value)
+			switch strings.ToLower(strings.TrimSpace(header[i])) {
+			case "ip":
+				device.IP = value
+			case "mac":
+				device.Mac = value
+			case "hostname":
+				device.HostName = value
+			case "name":
+				device.Name = value
+			case "company":
+				device.Company = value
+			case "position":
+				device.Position = value
+			case "type":
+				device.Type = value
+			case "quota_hourly", "quota_daily", "quota_monthly":
+				if value == "" {
+					continue
+				}
+				quota, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %v: wrong %v(%v)", n+2, header[i], value)
+				}
+				switch strings.ToLower(strings.TrimSpace(header[i])) {
+				case "quota_hourly":
+					device.QuotaHourly = quota
+				case "quota_daily":
+					device.QuotaDaily = quota
+				case "quota_monthly":
+					device.QuotaMonthly = quota
+				}
+			}
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+func (s *StaticDevices) find(line *LineOfData) (staticDevice, bool) {
+	if device, ok := s.byIP[line.IP]; ok {
+		return device, true
+	}
+	device, ok := s.byMac[line.Mac]
+	return device, ok
+}
+
+func (s *StaticDevices) apply(line *LineOfData, quota QuotaType) bool {
+	s.RLock()
+	defer s.RUnlock()
+	device, ok := s.find(line)
+	if !ok {
+		return false
+	}
+	set := func(field *string, value string) {
+		if value != "" && (s.high || *field == "") {
+			*field = value
+		}
+	}
+	if device.Mac != "" && (line.Mac == "" || line.Mac == line.IP) {
+		line.Mac = device.Mac
+	}
+	set(&line.Mac, device.Mac)
+	set(&line.HostName, device.HostName)
+	set(&line.Name, device.Name)
+	set(&line.Company, device.Company)
+	set(&line.Position, device.Position)
+	set(&line.TypeD, device.Type)
+	setQuota := func(field *uint64, value, defaultValue uint64) {
+		if value != 0 && (s.high || *field == 0 || *field == defaultValue) {
+			*field = value
+		}
+	}
+	setQuota(&line.HourlyQuota, device.QuotaHourly, quota.HourlyQuota)
+	setQuota(&line.DailyQuota, device.QuotaDaily, quota.DailyQuota)
+	setQuota(&line.MonthlyQuota, device.QuotaMonthly, quota.MonthlyQuota)
+	return true
+}
+
+func (s *StaticDevices) mergeInto(ipToMac map[string]LineOfData, quota QuotaType) {
+	for ip, line := range ipToMac {
+		if s.apply(&line, quota) {
+			ipToMac[ip] = line
+		}
+	}
+	// The devices with fixed IP addresses, which are not known to other sources
+	s.RLock()
+	ips := make([]string, 0, len(s.byIP))
+	for ip := range s.byIP {
+		if _, ok := ipToMac[ip]; !ok {
+			ips = append(ips, ip)
+		}
+	}
+	s.RUnlock()
+	for _, ip := range ips {
+		line := LineOfData{}
+		line.IP = ip
+		line.timeout = time.Now()
+		s.apply(&line, quota)
+		if line.Mac == "" {
+			line.Mac = ip
+		}
+		line.setDefaultQuotas(quota)
+		ipToMac[ip] = line
+	}
+}
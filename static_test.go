@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testStaticTOML = `[[device]]
+ip = "192.168.1.10"
+hostname = "printer1"
+name = "Reception printer"
+type = "prn"
+quota_daily = 100
+
+[[device]]
+mac = "aa-bb-cc-00-00-02"
+name = "Director laptop"
+quota_monthly = 5000
+`
+	testStaticCSV = `# The devices of the office
+Name, MAC, IP, Type, Quota_Daily, quota_monthly
+Reception printer,,192.168.1.10,prn,100,
+Director laptop,aa-bb-cc-00-00-02,,nb,,5000
+`
+)
+
+func writeStaticFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, modTime, modTime)
+}
+
+func TestStaticDevicesRead(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"devices.toml": testStaticTOML, "devices.csv": testStaticCSV} {
+		path := filepath.Join(dir, name)
+		writeStaticFile(t, path, content, time.Now())
+		s := newStaticDevices(path, "low")
+		if changed, err := s.read(); !changed || err != nil {
+			t.Fatalf("%v: changed %v, %v", name, changed, err)
+		}
+		printer, laptop := s.byIP["192.168.1.10"], s.byMac["AA:BB:CC:00:00:02"]
+		if printer.Name != "Reception printer" || printer.Type != "prn" || printer.QuotaDaily != 100 || printer.QuotaMonthly != 0 {
+			t.Errorf("%v: printer %+v", name, printer)
+		}
+		// The device with only a MAC address is found by it
+		if laptop.Name != "Director laptop" || laptop.QuotaMonthly != 5000 || laptop.IP != "" {
+			t.Errorf("%v: laptop %+v", name, laptop)
+		}
+		if len(s.byIP) != 1 || len(s.byMac) != 1 {
+			t.Errorf("%v: %v by IP, %v by MAC", name, len(s.byIP), len(s.byMac))
+		}
+		if changed, err := s.read(); changed || err != nil {
+			t.Errorf("%v: read again without changes: %v %v", name, changed, err)
+		}
+	}
+}
+
+func TestStaticDevicesReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.csv")
+	start := time.Now().Add(-time.Hour)
+	writeStaticFile(t, path, testStaticCSV, start)
+	s := newStaticDevices(path, "low")
+	if _, err := s.read(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A wrong quota keeps the old mapping until the file is fixed
+	writeStaticFile(t, path, "ip,quota_daily\n192.168.1.20,lots\n", start.Add(time.Minute))
+	if changed, err := s.read(); changed || err == nil {
+		t.Errorf("wrong quota: changed %v, %v", changed, err)
+	}
+	if _, ok := s.byIP["192.168.1.10"]; !ok || len(s.byIP) != 1 {
+		t.Errorf("the old mapping is not kept: %+v", s.byIP)
+	}
+
+	writeStaticFile(t, path, "ip,quota_daily\n192.168.1.20,200\n", start.Add(2*time.Minute))
+	if changed, err := s.read(); !changed || err != nil {
+		t.Fatalf("changed %v, %v", changed, err)
+	}
+	if _, ok := s.byIP["192.168.1.10"]; ok || s.byIP["192.168.1.20"].QuotaDaily != 200 {
+		t.Errorf("the file is not reloaded: %+v", s.byIP)
+	}
+
+	s.path = filepath.Join(filepath.Dir(path), "devices.toml")
+	writeStaticFile(t, s.path, "[[device]]\nip = \"192.168.1.30\"\nquota_daily = \"lots\"\n", start.Add(3*time.Minute))
+	if _, err := s.read(); err == nil {
+		t.Error("no error for a wrong quota in TOML")
+	}
+}
+
+func TestStaticDevicesApply(t *testing.T) {
+	quota := QuotaType{HourlyQuota: 1, DailyQuota: 10, MonthlyQuota: 100}
+	for _, priority := range []string{"low", "high"} {
+		s := newStaticDevices("", priority)
+		s.byIP = map[string]staticDevice{
+			"192.168.1.10": {IP: "192.168.1.10", Name: "Reception printer", Type: "prn", QuotaDaily: 50},
+			"192.168.1.11": {IP: "192.168.1.11", Mac: "AA:BB:CC:00:00:11", HostName: "nas"},
+		}
+		s.byMac = map[string]staticDevice{"AA:BB:CC:00:00:02": {Mac: "AA:BB:CC:00:00:02", Name: "Director laptop", QuotaMonthly: 5000}}
+
+		printer := LineOfData{}
+		printer.IP, printer.Mac, printer.Name, printer.DailyQuota = "192.168.1.10", "AA:BB:CC:00:00:10", "From the lease", 20
+		laptop := LineOfData{}
+		laptop.IP, laptop.Mac, laptop.DailyQuota, laptop.MonthlyQuota = "192.168.1.12", "AA:BB:CC:00:00:02", 10, 100
+		ipToMac := map[string]LineOfData{"192.168.1.10": printer, "192.168.1.12": laptop}
+		s.mergeInto(ipToMac, quota)
+
+		printer, laptop, nas := ipToMac["192.168.1.10"], ipToMac["192.168.1.12"], ipToMac["192.168.1.11"]
+		wantName, wantQuota := "From the lease", uint64(20)
+		if priority == "high" {
+			wantName, wantQuota = "Reception printer", 50
+		}
+		if printer.Name != wantName || printer.DailyQuota != wantQuota || printer.TypeD != "prn" {
+			t.Errorf("%v: printer %v %v %v", priority, printer.Name, printer.DailyQuota, printer.TypeD)
+		}
+		// The default quota is not a quota of the router
+		if laptop.Name != "Director laptop" || laptop.MonthlyQuota != 5000 {
+			t.Errorf("%v: laptop %v %v", priority, laptop.Name, laptop.MonthlyQuota)
+		}
+		if nas.Mac != "AA:BB:CC:00:00:11" || nas.HostName != "nas" || nas.DailyQuota != 10 {
+			t.Errorf("%v: the device not known to other sources %+v", priority, nas)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-routeros/routeros"
+	log "github.com/sirupsen/logrus"
+)
+
+// The first pause between the attempts to reconnect, it doubles up to -mt_reconnect_max_interval
+const minReconnectInterval = 5 * time.Second
+
+var errRouterDown = errors.New("the connection to the router is lost, reconnecting")
+
+// supervisedClient keeps the connection to the router. A lost connection opens the circuit: the commands fail at once
+// instead of waiting for the dead router, while the supervisor reconnects with exponential backoff.
+// The connection is also checked every -mt_health_interval, as the router may go away between the polls.
+// The client starts with the open circuit and the first connection is made by the supervisor,
+// so a router which is not available at the start does not hold up the other sources.
+type supervisedClient struct {
+	router *router
+	// nil while the circuit is open
+	client routerClient
+	dial   func(r *router) (routerClient, error)
+	// receives after each connection, so that the devices are polled without waiting for -interval
+	connectedChan chan<- struct{}
+	downSince     time.Time
+	// the connection has been made at least once
+	everConnected bool
+	closed        bool
+	lostChan      chan struct{}
+	sync.RWMutex
+}
+
+func newSupervisedClient(r *router, connectedChan chan<- struct{}) *supervisedClient {
+	return &supervisedClient{router: r, dial: dial, connectedChan: connectedChan, downSince: time.Now(), lostChan: make(chan struct{}, 1)}
+}
+
+// connectionLost reports whether the error is of the connection, not of the command, e.g. a wrong query.
+func connectionLost(err error) bool {
+	// The deadline of the caller, e.g. of a resolver, is not of the connection
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var deviceErr *routeros.DeviceError
+	if errors.As(err, &deviceErr) {
+		// !fatal closes the session, !trap is the error of the command
+		return deviceErr.Sentence.Word == "!fatal"
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (s *supervisedClient) Run(sentence ...string) (*routeros.Reply, error) {
+	return s.RunContext(context.Background(), sentence...)
+}
+
+func (s *supervisedClient) RunContext(ctx context.Context, sentence ...string) (*routeros.Reply, error) {
+	s.RLock()
+	c := s.client
+	s.RUnlock()
+	if c == nil {
+		return nil, errRouterDown
+	}
+	reply, err := c.RunContext(ctx, sentence...)
+	if err != nil && connectionLost(err) {
+		s.lost(c, err)
+	}
+	return reply, err
+}
+
+func (s *supervisedClient) connected() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.client != nil
+}
+
+func (s *supervisedClient) lost(c routerClient, err error) {
+	s.Lock()
+	if s.client != c {
+		// Another command has already found the connection lost
+		s.Unlock()
+		return
+	}
+	s.client = nil
+	s.downSince = time.Now()
+	s.Unlock()
+	c.Close()
+	log.Errorf("Lost the connection to %v, the last known devices are kept until it is back:%v", s.router.name, err)
+	select {
+	case s.lostChan <- struct{}{}:
+	default:
+	}
+}
+
+// supervise checks the connection while it is up and reconnects when it is lost.
+func (s *supervisedClient) supervise() {
+	healthInterval := parseDurationOr(cfg.MTHealthInterval, 30*time.Second)
+	maxInterval := parseDurationOr(cfg.MTReconnectMaxInterval, 5*time.Minute)
+	interval := minReconnectInterval
+	for {
+		s.RLock()
+		closed, up := s.closed, s.client != nil
+		s.RUnlock()
+		if closed {
+			return
+		}
+		if up {
+			select {
+			case <-s.lostChan:
+			case <-time.After(healthInterval):
+				// The errors of the connection open the circuit in Run
+				if _, err := s.Run("/system/identity/print"); err != nil {
+					log.Debugf("Health check of %v failed:%v", s.router.name, err)
+				}
+			}
+			continue
+		}
+
+		c, err := s.dial(s.router)
+		if err != nil {
+			log.Errorf("Error connect to %v, the next attempt in %v:%v", s.router.address, interval, err)
+			time.Sleep(interval)
+			if interval *= 2; interval > maxInterval {
+				interval = maxInterval
+			}
+			continue
+		}
+		s.Lock()
+		if s.closed {
+			s.Unlock()
+			c.Close()
+			return
+		}
+		s.client = c
+		downtime, reconnected := time.Since(s.downSince), s.everConnected
+		s.everConnected = true
+		s.Unlock()
+		interval = minReconnectInterval
+		if reconnected {
+			log.Infof("Reconnected to %v after %v", s.router.name, downtime.Round(time.Second))
+		} else {
+			log.Infof("Connected to %v", s.router.name)
+		}
+		select {
+		case s.connectedChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *supervisedClient) Close() {
+	s.Lock()
+	c := s.client
+	s.client, s.closed = nil, true
+	s.Unlock()
+	if c != nil {
+		c.Close()
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-routeros/routeros"
+)
+
+func TestSupervisedClientConnects(t *testing.T) {
+	dialed := make(chan chan routerClient, 1)
+	connected := make(chan struct{}, 1)
+	s := newSupervisedClient(&router{name: "gw", address: "192.0.2.1:8728"}, connected)
+	s.dial = func(r *router) (routerClient, error) {
+		c := make(chan routerClient)
+		dialed <- c
+		return <-c, nil
+	}
+	go s.supervise()
+	defer s.Close()
+
+	// The first dial does not hold up the commands, they fail at once
+	connect := <-dialed
+	if _, err := s.Run("/ip/arp/print"); err != errRouterDown {
+		t.Errorf("before the connection: %v", err)
+	}
+
+	alive := true
+	connect <- &fakeRouterClient{run: func(sentence ...string) (*routeros.Reply, error) {
+		if !alive {
+			return nil, io.EOF
+		}
+		return testReply(map[string]string{"address": "10.0.0.1"}), nil
+	}}
+	waitConnected(t, s)
+	waitSignal(t, connected)
+	if reply, err := s.Run("/ip/arp/print"); err != nil || len(reply.Re) != 1 {
+		t.Errorf("after the connection: %v %v", reply, err)
+	}
+
+	// A lost connection opens the circuit and the supervisor dials again
+	alive = false
+	if _, err := s.Run("/ip/arp/print"); !errors.Is(err, io.EOF) {
+		t.Errorf("lost connection: %v", err)
+	}
+	if _, err := s.Run("/ip/arp/print"); err != errRouterDown {
+		t.Errorf("open circuit: %v", err)
+	}
+	connect = <-dialed
+	alive = true
+	connect <- &fakeRouterClient{run: func(sentence ...string) (*routeros.Reply, error) { return testReply(), nil }}
+	waitConnected(t, s)
+	waitSignal(t, connected)
+}
+
+func waitSignal(t *testing.T, c chan struct{}) {
+	t.Helper()
+	select {
+	case <-c:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no signal of the connection")
+	}
+}
+
+func waitConnected(t *testing.T, s *supervisedClient) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if s.connected() {
+			return
+		}
+	}
+	t.Fatal("not connected")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type wgPeer struct {
+	Name,
+	Comment,
+	Interface,
+	Endpoint,
+	Router string
+	networks []*net.IPNet
+}
+
+type WireGuard struct {
+	peers []wgPeer
+	sync.RWMutex
+}
+
+// getWireGuardFromMT gets the peers of the WireGuard interfaces (RouterOS v7) of the routers.
+func (data *Transport) getWireGuardFromMT() {
+	peers := []wgPeer{}
+	failed := data.pollRouters("WireGuard peers", func(r *router) error {
+		reply, err := r.client.Run("/interface/wireguard/peers/print")
+		if err != nil {
+			return err
+		}
+		for _, re := range reply.Re {
+			if re.Map["disabled"] == "true" {
+				continue
+			}
+			peer := wgPeer{
+				Name:      re.Map["name"],
+				Comment:   re.Map["comment"],
+				Interface: re.Map["interface"],
+				Endpoint:  re.Map["current-endpoint-address"],
+				Router:    r.name,
+			}
+			for _, address := range strings.Split(re.Map["allowed-address"], ",") {
+				network, err := parseAllowedAddress(address)
+				if err != nil {
+					log.Errorf("Error parse allowed-address(%v) of WireGuard peer(%v):%v", address, peer.Name, err)
+					continue
+				}
+				peer.networks = append(peer.networks, network)
+			}
+			peers = append(peers, peer)
+		}
+		return nil
+	})
+
+	data.wireGuard.Lock()
+	for _, peer := range data.wireGuard.peers {
+		if failed[peer.Router] {
+			peers = append(peers, peer)
+		}
+	}
+	data.wireGuard.peers = peers
+	data.wireGuard.Unlock()
+	log.Tracef("Get %v WireGuard peers from mikrotik", len(peers))
+}
+
+func parseAllowedAddress(address string) (*net.IPNet, error) {
+	address = strings.TrimSpace(address)
+	if !strings.Contains(address, "/") {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+			address += "/32"
+		} else {
+			address += "/128"
+		}
+	}
+	_, network, err := net.ParseCIDR(address)
+	return network, err
+}
+
+// find returns the peer with the most specific allowed-address containing the IP.
+func (wg *WireGuard) find(ip string) (wgPeer, bool) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return wgPeer{}, false
+	}
+	wg.RLock()
+	defer wg.RUnlock()
+	var (
+		result wgPeer
+		best   = -1
+	)
+	for _, peer := range wg.peers {
+		for _, network := range peer.networks {
+			ones, _ := network.Mask.Size()
+			if ones > best && network.Contains(addr) {
+				result = peer
+				best = ones
+			}
+		}
+	}
+	return result, best >= 0
+}
+
+// apply puts the name of the peer as the login and its comment into the line.
+// Lines with a real MAC address came from ARP or DHCP and are not WireGuard clients
+// even when a site-to-site peer allows the whole network.
+func (wg *WireGuard) apply(line *LineOfData, quota QuotaType) bool {
+	if (line.Mac != "" && line.Mac != line.IP) || (line.Service != "" && line.Service != "wireguard") {
+		return false
+	}
+	peer, ok := wg.find(line.IP)
+	if !ok {
+		return false
+	}
+	line.Service = "wireguard"
+	line.CallerID = peer.Endpoint
+	if line.Router == "" {
+		line.Router = peer.Router
+	}
+	if peer.Comment != "" {
+		line.applyComment(peer.Comment, quota)
+	}
+	line.Login = peer.Name
+	if line.Login == "" {
+		line.Login = line.Name
+	}
+	return true
+}
+
+// mergeInto adds the peers with a single allowed address and updates the lines
+// which are already known and fall into the allowed networks.
+func (wg *WireGuard) mergeInto(ipToMac map[string]LineOfData, quota QuotaType) {
+	ips := []string{}
+	wg.RLock()
+	for _, peer := range wg.peers {
+		for _, network := range peer.networks {
+			if ones, bits := network.Mask.Size(); ones == bits {
+				ips = append(ips, network.IP.String())
+			}
+		}
+	}
+	wg.RUnlock()
+	for ip := range ipToMac {
+		ips = append(ips, ip)
+	}
+
+	mergeIPs(ipToMac, ips, quota, func(line *LineOfData) bool {
+		return wg.apply(line, quota)
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type wirelessClient struct {
+	Mac,
+	SSID,
+	AP,
+	Signal,
+	PrevAP,
+	Router string
+	roams     []time.Time
+	lastSeen  time.Time
+	connected bool
+}
+
+type Wireless struct {
+	clients map[string]wirelessClient
+	sync.RWMutex
+}
+
+// Registration tables of the legacy wireless package, CAPsMAN and the wifi packages of RouterOS v7.
+var wirelessRegistrationTables = []struct {
+	command,
+	signal string
+}{
+	{"/interface/wireless/registration-table/print", "signal-strength"},
+	{"/caps-man/registration-table/print", "rx-signal"},
+	{"/interface/wifi/registration-table/print", "signal"},
+	{"/interface/wifiwave2/registration-table/print", "signal"},
+}
+
+func (data *Transport) loopGetDataFromWireless() {
+	for {
+		data.getWirelessFromMT()
+
+		interval, err := time.ParseDuration(cfg.WirelessInterval)
+		if err != nil {
+			interval = time.Minute
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (data *Transport) getWirelessFromMT() {
+	clients := map[string]wirelessClient{}
+	failed := data.pollRouters("wireless clients", func(r *router) error {
+		// The legacy registration table does not contain SSID, it is taken from the interface
+		ssids := map[string]string{}
+		reply, err := r.client.Run("/interface/wireless/print")
+		if err == errRouterDown {
+			return err
+		}
+		if err != nil {
+			log.Debugf("Error getting wireless interfaces from %v:%v", r.name, err)
+		} else {
+			for _, re := range reply.Re {
+				ssids[re.Map["name"]] = re.Map["ssid"]
+			}
+		}
+
+		for _, table := range wirelessRegistrationTables {
+			reply, err := r.client.Run(table.command)
+			if err == errRouterDown {
+				return err
+			}
+			if err != nil {
+				// Not every router has every wireless package
+				log.Debugf("Error getting %v from %v:%v", table.command, r.name, err)
+				continue
+			}
+			for _, re := range reply.Re {
+				client := wirelessClient{
+					Mac:    strings.ToUpper(re.Map["mac-address"]),
+					AP:     re.Map["interface"],
+					SSID:   re.Map["ssid"],
+					Signal: strings.Split(re.Map[table.signal], "@")[0],
+					Router: r.name,
+				}
+				if client.SSID == "" {
+					client.SSID = ssids[client.AP]
+				}
+				clients[client.Mac] = client
+			}
+		}
+		return nil
+	})
+	data.wireless.RLock()
+	for mac, client := range data.wireless.clients {
+		if _, ok := clients[mac]; !ok && client.connected && failed[client.Router] {
+			clients[mac] = client
+		}
+	}
+	data.wireless.RUnlock()
+
+	window, err := time.ParseDuration(cfg.WirelessRoamWindow)
+	if err != nil {
+		window = time.Hour
+	}
+	data.wireless.update(clients, time.Now(), window)
+	log.Tracef("Get %v wireless clients from mikrotik", len(clients))
+
+	data.Lock()
+	data.wireless.mergeInto(data.ipToMac)
+	data.Unlock()
+}
+
+// update replaces the registered clients and counts the moves of each MAC between APs within the window.
+func (w *Wireless) update(clients map[string]wirelessClient, now time.Time, window time.Duration) {
+	w.Lock()
+	defer w.Unlock()
+	if w.clients == nil {
+		w.clients = map[string]wirelessClient{}
+	}
+	for mac, client := range clients {
+		old, ok := w.clients[mac]
+		client.roams = old.roams
+		client.PrevAP = old.PrevAP
+		if ok && old.AP != client.AP {
+			log.Debugf("MAC:%v moved from AP:%v to AP:%v", mac, old.AP, client.AP)
+			client.roams = append(client.roams, now)
+			client.PrevAP = old.AP
+		}
+		client.lastSeen = now
+		client.connected = true
+		w.clients[mac] = client
+	}
+	for mac, client := range w.clients {
+		if _, ok := clients[mac]; !ok {
+			client.connected = false
+		}
+		if now.Sub(client.lastSeen) > window {
+			delete(w.clients, mac)
+			continue
+		}
+		roams := client.roams[:0]
+		for _, roam := range client.roams {
+			if now.Sub(roam) < window {
+				roams = append(roams, roam)
+			}
+		}
+		client.roams = roams
+		w.clients[mac] = client
+	}
+}
+
+// apply puts the attributes of the wireless registration into the device.
+func (w *Wireless) apply(device *DeviceType) bool {
+	w.RLock()
+	defer w.RUnlock()
+	client, ok := w.clients[strings.ToUpper(device.Mac)]
+	if !ok || !client.connected {
+		return false
+	}
+	device.SSID = client.SSID
+	device.AP = client.AP
+	device.Signal = client.Signal
+	device.PrevAP = client.PrevAP
+	device.Roams = len(client.roams)
+	if device.Router == "" {
+		device.Router = client.Router
+	}
+	return true
+}
+
+func (w *Wireless) mergeInto(ipToMac map[string]LineOfData) {
+	for ip, line := range ipToMac {
+		if w.apply(&line.DeviceType) {
+			ipToMac[ip] = line
+		}
+	}
+}